@@ -1,33 +1,87 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/activation"
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/encoding/hexutil"
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/proxyproto"
 	salty "github.com/OguzhanE/saltyrtc-server-go/salty"
 )
 
 var server *salty.Server
 
+// names of the listeners passed by LISTEN_FDNAMES
+const (
+	serverFdName  = "salty"
+	metricsFdName = "metrics"
+	adminFdName   = "admin"
+)
+
 func main() {
 
 	var flags struct {
-		Addr      string
-		Port      uint
-		Verbosity int
-		Pk        string
-		Sk        string
+		Addr             string
+		Port             uint
+		Verbosity        int
+		Pk               string
+		Sk               string
+		HandshakeTimeout uint
+		MaxOutboundBytes int
+		MaxMessageSize   int
+		ShutdownTimeout  uint
+		DrainPathsOnly   bool
+		MaxDrainTime     uint
+		NumLoops         int
+		CertFile         string
+		KeyFile          string
+		ClientCAFile     string
+		SocketMode       uint
+		ProxyTrusted     string
+		MetricsAddr      string
+		AdminAddr        string
+		AdminToken       string
+		AllowFile        string
+		DenyFile         string
+		TokenSecret      string
 	}
 
-	flag.StringVar(&flags.Addr, "a", "", "Address")
+	flag.StringVar(&flags.Addr, "a", "", "Comma separated addresses, unix:<path> listens on a unix domain socket")
 	flag.UintVar(&flags.Port, "p", 3838, "Port")
 	flag.IntVar(&flags.Verbosity, "v", 10, "Logging Verbosity")
 	flag.StringVar(&flags.Pk, "pk", "", "Public key of server permanent key in hex format")
 	flag.StringVar(&flags.Sk, "sk", "", "Secret key of server permanent key in hex format")
+	flag.UintVar(&flags.HandshakeTimeout, "ht", 30, "Handshake timeout in seconds, 0 disables it")
+	flag.IntVar(&flags.MaxOutboundBytes, "ob", salty.DefaultMaxOutboundBytes, "Outbound queue limit of a connection in bytes, 0 disables it")
+	flag.IntVar(&flags.MaxMessageSize, "mm", salty.DefaultMaxMessageSize, "Maximum size of a message received in bytes, 0 disables it")
+	flag.UintVar(&flags.ShutdownTimeout, "st", 10, "Graceful shutdown timeout in seconds")
+	flag.BoolVar(&flags.DrainPathsOnly, "dp", false, "Reject only new paths while draining")
+	flag.UintVar(&flags.MaxDrainTime, "dt", 0, "Maximum drain time in seconds, 0 disables it")
+	flag.IntVar(&flags.NumLoops, "loops", 1, "Number of event loops")
+	flag.StringVar(&flags.CertFile, "cert", "", "TLS certificate file, enables wss://")
+	flag.StringVar(&flags.KeyFile, "key", "", "TLS private key file")
+	flag.StringVar(&flags.ClientCAFile, "clientca", "", "CA file verifying the client certificates, enables the client certificate mode")
+	flag.UintVar(&flags.SocketMode, "sm", salty.DefaultUnixSocketMode, "File mode of the unix domain socket")
+	flag.StringVar(&flags.ProxyTrusted, "proxy", "", "Comma separated CIDRs of the proxies sending the PROXY protocol header")
+	flag.StringVar(&flags.MetricsAddr, "metrics", "", "Address of the HTTP listener serving the metrics at /metrics, disabled if empty")
+	flag.StringVar(&flags.AdminAddr, "admin", "", "Address of the admin API listener, unix:<path> listens on a unix domain socket, disabled if empty")
+	flag.StringVar(&flags.AdminToken, "admintoken", os.Getenv("SALTY_ADMIN_TOKEN"), "Bearer token of the admin API, defaults to $SALTY_ADMIN_TOKEN")
+	flag.StringVar(&flags.AllowFile, "allow", "", "File of the initiator keys allowed to connect, one per line")
+	flag.StringVar(&flags.DenyFile, "deny", "", "File of the initiator keys denied to connect, one per line")
+	flag.StringVar(&flags.TokenSecret, "tokensecret", os.Getenv("SALTY_TOKEN_SECRET"), "Secret signing the access tokens required in the token query parameter, defaults to $SALTY_TOKEN_SECRET, disabled if empty")
 	flag.Parse()
 
 	if flags.Sk == "" || flags.Pk == "" {
@@ -45,17 +99,309 @@ func main() {
 
 	salty.InitLogger(flags.Verbosity)
 
-	addr := fmt.Sprintf("%s:%d", flags.Addr, flags.Port)
+	// the listeners passed by systemd or by the process handing over its listeners,
+	// the ones named metrics and admin serve the metrics and the admin API
+	files, err := activation.Files()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var inherited []net.Listener
+	named := make(map[string]net.Listener)
+	for _, f := range files {
+		ln, err := net.FileListener(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+		if name := f.Name(); name == metricsFdName || name == adminFdName {
+			named[name] = ln
+		} else {
+			inherited = append(inherited, ln)
+		}
+	}
+
+	var addrs []string
+	for _, addr := range strings.Split(flags.Addr, ",") {
+		if _, _, err := net.SplitHostPort(addr); err != nil && !strings.HasPrefix(addr, "unix:") {
+			addr = fmt.Sprintf("%s:%d", addr, flags.Port)
+		}
+		addrs = append(addrs, addr)
+	}
 
 	defaultBox := nacl.NewBoxKeyPair(*pkBytes, *skBytes)
 	salty.Sugar.Info("Starting server with the public permanent key: ", flags.Pk)
 
 	server = salty.NewServer(*defaultBox)
-	server.Start(addr)
-	quit := make(chan interface{})
-	select {
-	case <-quit:
-		os.Exit(0)
-		return
+	server.HandshakeTimeout = time.Duration(flags.HandshakeTimeout) * time.Second
+	server.MaxOutboundBytes = flags.MaxOutboundBytes
+	server.MaxMessageSize = flags.MaxMessageSize
+	server.DrainNewPathsOnly = flags.DrainPathsOnly
+	server.MaxDrainTime = time.Duration(flags.MaxDrainTime) * time.Second
+	server.NumLoops = flags.NumLoops
+	server.UnixSocketMode = os.FileMode(flags.SocketMode)
+	if flags.ProxyTrusted != "" {
+		server.ProxyTrusted, err = proxyproto.ParseTrusted(strings.Split(flags.ProxyTrusted, ",")...)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var certs *salty.CertReloader
+	if flags.CertFile != "" {
+		if certs, err = salty.NewCertReloader(flags.CertFile, flags.KeyFile); err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig, err = salty.NewTLSConfig(certs, flags.ClientCAFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var keys *salty.KeyList
+	switch {
+	case flags.AllowFile != "" && flags.DenyFile != "":
+		log.Fatal("Only one of -allow and -deny can be given")
+	case flags.AllowFile != "":
+		keys, err = salty.NewAllowList(flags.AllowFile)
+	case flags.DenyFile != "":
+		keys, err = salty.NewDenyList(flags.DenyFile)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var authorizers salty.Authorizers
+	if keys != nil {
+		authorizers = append(authorizers, keys)
+	}
+	if flags.TokenSecret != "" {
+		authorizers = append(authorizers, &salty.TokenAuthorizer{Secret: []byte(flags.TokenSecret)})
+	}
+	if len(authorizers) > 0 {
+		server.Authorizer = authorizers
+	}
+
+	go func() {
+		// SIGHUP reloads the certificate and the key list
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			if certs != nil {
+				if err := certs.Reload(); err != nil {
+					salty.Sugar.Error("Could not reload the certificate :", err)
+				} else {
+					salty.Sugar.Info("Certificate reloaded")
+				}
+			}
+			if keys != nil {
+				if err := keys.Reload(); err != nil {
+					salty.Sugar.Error("Could not reload the key list :", err)
+				} else {
+					salty.Sugar.Info("Key list reloaded")
+				}
+			}
+		}
+	}()
+
+	go func() {
+		// SIGUSR1 toggles the drain mode
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGUSR1)
+		for range sig {
+			if server.Draining() {
+				server.Undrain()
+			} else {
+				server.Drain()
+			}
+		}
+	}()
+
+	metricsLn := named[metricsFdName]
+	if metricsLn == nil && flags.MetricsAddr != "" {
+		if metricsLn, err = net.Listen("tcp", flags.MetricsAddr); err != nil {
+			log.Fatal(err)
+		}
+		named[metricsFdName] = metricsLn
+	}
+	// the HTTP servers of the named listeners, they are closed once the listeners are handed over
+	var httpServers []*http.Server
+	if metricsLn != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", server.Metrics())
+		metricsServer := &http.Server{Handler: mux}
+		httpServers = append(httpServers, metricsServer)
+		go func() {
+			salty.Sugar.Info("Serving metrics on ", metricsLn.Addr())
+			if err := metricsServer.Serve(metricsLn); err != http.ErrServerClosed {
+				salty.Sugar.Error("Could not serve metrics :", err)
+			}
+		}()
+	}
+
+	adminLn := named[adminFdName]
+	if adminLn == nil && flags.AdminAddr != "" {
+		if adminLn, err = listenAdmin(flags.AdminAddr); err != nil {
+			log.Fatal(err)
+		}
+		named[adminFdName] = adminLn
+	}
+	if adminLn != nil {
+		if flags.AdminToken == "" {
+			log.Fatal("The admin API requires a token")
+		}
+		adminServer := &http.Server{Handler: server.AdminHandler(flags.AdminToken)}
+		httpServers = append(httpServers, adminServer)
+		go func() {
+			salty.Sugar.Info("Serving the admin API on ", adminLn.Addr())
+			if err := adminServer.Serve(adminLn); err != http.ErrServerClosed {
+				salty.Sugar.Error("Could not serve the admin API :", err)
+			}
+		}()
+	}
+
+	handedOver := make(chan struct{})
+	go func() {
+		// SIGUSR2 starts a new process taking over the listeners, this one drains
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGUSR2)
+		for range sig {
+			pid, err := handOver(server, named)
+			if err != nil {
+				salty.Sugar.Error("Could not hand the listeners over :", err)
+				continue
+			}
+			salty.Sugar.Info("Handed the listeners over to the process: ", pid)
+			signal.Stop(sig)
+			server.StopListening()
+			server.Drain()
+			// the metrics and the admin API are served by the new process only
+			timeout := time.Duration(flags.ShutdownTimeout) * time.Second
+			stopServingNamed(httpServers, named, timeout)
+			close(handedOver)
+			return
+		}
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		select {
+		case s := <-sig:
+			salty.Sugar.Info("Received signal: ", s)
+		case <-handedOver:
+			// the clients left are closed after the maximum drain time if it is set
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				server.WaitIdle(ctx)
+				cancel()
+			}()
+			select {
+			case <-ctx.Done():
+			case s := <-sig:
+				salty.Sugar.Info("Received signal: ", s)
+			}
+			cancel()
+		}
+
+		timeout := time.Duration(flags.ShutdownTimeout) * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			salty.Sugar.Warn("Server could not shut down gracefully :", err)
+		}
+	}()
+
+	if len(inherited) > 0 {
+		err = server.Serve(inherited...)
+	} else {
+		err = server.Start(addrs...)
+	}
+	if err != salty.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+}
+
+// listenAdmin listens on the TCP address or the unix domain socket of the admin API,
+// the socket is accessible only by the user of the process
+func listenAdmin(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// stopServingNamed shuts servers down after the named listeners are handed over, the socket
+// files of the unix listeners are kept for the new process
+func stopServingNamed(servers []*http.Server, named map[string]net.Listener, timeout time.Duration) {
+	for _, ln := range named {
+		if unixln, ok := ln.(*net.UnixListener); ok {
+			unixln.SetUnlinkOnClose(false)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+}
+
+// filer is implemented by *net.TCPListener and *net.UnixListener
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handOver starts a new process of the same executable and arguments, passing
+// the listeners of server and the named ones by LISTEN_FDS. It returns the pid of the new process
+func handOver(server *salty.Server, named map[string]net.Listener) (int, error) {
+	files, err := server.ListenerFiles()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, len(files))
+	for i := range names {
+		names[i] = serverFdName
+	}
+	for name, ln := range named {
+		f, err := ln.(filer).File()
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, f)
+		names = append(names, name)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"))
+	if err := cmd.Start(); err != nil {
+		return 0, err
 	}
+	go cmd.Wait()
+	return cmd.Process.Pid, nil
 }
@@ -0,0 +1,77 @@
+// Package activation picks up the listening sockets passed by a service manager
+// following the systemd socket activation protocol, or by a parent process
+package activation
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed, the ones before are stdin, stdout and stderr
+const listenFdsStart = 3
+
+// ErrInvalidListenFds occurs when LISTEN_FDS is not a number of file descriptors
+var ErrInvalidListenFds = errors.New("activation: invalid LISTEN_FDS")
+
+// Files returns the files passed by LISTEN_FDS, named by LISTEN_FDNAMES.
+// They are passed to the process whose pid is LISTEN_PID, or to any process when it is unset.
+// The variables are unset, so they are not inherited by the child processes
+func Files() ([]*os.File, error) {
+	return files(listenFdsStart)
+}
+
+// Listeners returns the listeners of the sockets passed by LISTEN_FDS in their order
+func Listeners() ([]net.Listener, error) {
+	files, err := Files()
+	if err != nil {
+		return nil, err
+	}
+	lns := make([]net.Listener, 0, len(files))
+	for i, f := range files {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			for _, f := range files[i+1:] {
+				f.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func files(start int) ([]*os.File, error) {
+	pid := os.Getenv("LISTEN_PID")
+	nfds := os.Getenv("LISTEN_FDS")
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if nfds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(nfds)
+	if err != nil || n < 0 {
+		return nil, ErrInvalidListenFds
+	}
+
+	files := make([]*os.File, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - start; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files, nil
+}
@@ -0,0 +1,58 @@
+package activation
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiles_NotActivated(t *testing.T) {
+	os.Unsetenv("LISTEN_FDS")
+	files, err := Files()
+	require.Nil(t, err)
+	require.Empty(t, files)
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	files, err = Files()
+	require.Nil(t, err)
+	require.Empty(t, files)
+	require.Empty(t, os.Getenv("LISTEN_FDS"))
+}
+
+func TestFiles_Invalid(t *testing.T) {
+	os.Setenv("LISTEN_FDS", "x")
+	_, err := Files()
+	require.Equal(t, ErrInvalidListenFds, err)
+}
+
+func TestFiles(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	require.Nil(t, err)
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.Nil(t, err)
+	f.Close()
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_FDNAMES", "salty")
+	files, err := files(fd)
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "salty", files[0].Name())
+	require.Empty(t, os.Getenv("LISTEN_PID"))
+	require.Empty(t, os.Getenv("LISTEN_FDNAMES"))
+
+	inherited, err := net.FileListener(files[0])
+	require.Nil(t, err)
+	defer inherited.Close()
+	require.Equal(t, ln.Addr().String(), inherited.Addr().String())
+	files[0].Close()
+}
@@ -0,0 +1,300 @@
+// Package metrics keeps counters and gauges and exposes them in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Counter is a value that only increases
+type Counter struct {
+	v uint64
+}
+
+// Inc increments c by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+// Add increments c by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Value returns the value of c
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	v int64
+}
+
+// Inc increments g by one
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.v, 1)
+}
+
+// Dec decrements g by one
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.v, -1)
+}
+
+// Set sets g to v
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.v, v)
+}
+
+// Value returns the value of g
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+// CounterVec is a set of counters partitioned by the values of its labels
+type CounterVec struct {
+	labels   []string
+	mux      sync.RWMutex
+	counters map[string]*Counter
+}
+
+// With returns the counter of the label values, which are given in the order of the labels
+func (v *CounterVec) With(values ...string) *Counter {
+	key := labelKey(v.labels, values)
+	v.mux.RLock()
+	c, ok := v.counters[key]
+	v.mux.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if c, ok = v.counters[key]; !ok {
+		c = &Counter{}
+		v.counters[key] = c
+	}
+	return c
+}
+
+// GaugeVec is a set of gauges partitioned by the values of its labels
+type GaugeVec struct {
+	labels []string
+	mux    sync.RWMutex
+	gauges map[string]*Gauge
+}
+
+// With returns the gauge of the label values, which are given in the order of the labels
+func (v *GaugeVec) With(values ...string) *Gauge {
+	key := labelKey(v.labels, values)
+	v.mux.RLock()
+	g, ok := v.gauges[key]
+	v.mux.RUnlock()
+	if ok {
+		return g
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if g, ok = v.gauges[key]; !ok {
+		g = &Gauge{}
+		v.gauges[key] = g
+	}
+	return g
+}
+
+type family struct {
+	name  string
+	help  string
+	typ   string
+	write func(w io.Writer, name string)
+}
+
+// Registry is a set of metrics written in the order they are created
+type Registry struct {
+	mux      sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates new Registry instance
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(name, help, typ string, write func(w io.Writer, name string)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic("metrics: duplicate metric " + name)
+		}
+	}
+	r.families = append(r.families, &family{name: name, help: help, typ: typ, write: write})
+}
+
+// NewCounter creates a counter named name
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{}
+	r.add(name, help, "counter", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	})
+	return c
+}
+
+// NewGauge creates a gauge named name
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.add(name, help, "gauge", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, g.Value())
+	})
+	return g
+}
+
+// NewGaugeFunc creates a gauge named name whose value is returned by f when it is written
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.add(name, help, "gauge", func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(f()))
+	})
+}
+
+// NewCounterVec creates a set of counters named name partitioned by labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		labels:   labels,
+		counters: make(map[string]*Counter),
+	}
+	r.add(name, help, "counter", func(w io.Writer, name string) {
+		v.mux.RLock()
+		keys := make([]string, 0, len(v.counters))
+		for key := range v.counters {
+			keys = append(keys, key)
+		}
+		v.mux.RUnlock()
+		sort.Strings(keys)
+		for _, key := range keys {
+			v.mux.RLock()
+			c := v.counters[key]
+			v.mux.RUnlock()
+			fmt.Fprintf(w, "%s{%s} %d\n", name, key, c.Value())
+		}
+	})
+	return v
+}
+
+// NewGaugeVec creates a set of gauges named name partitioned by labels
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{
+		labels: labels,
+		gauges: make(map[string]*Gauge),
+	}
+	r.add(name, help, "gauge", func(w io.Writer, name string) {
+		v.mux.RLock()
+		keys := make([]string, 0, len(v.gauges))
+		for key := range v.gauges {
+			keys = append(keys, key)
+		}
+		v.mux.RUnlock()
+		sort.Strings(keys)
+		for _, key := range keys {
+			v.mux.RLock()
+			g := v.gauges[key]
+			v.mux.RUnlock()
+			fmt.Fprintf(w, "%s{%s} %d\n", name, key, g.Value())
+		}
+	})
+	return v
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mux.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mux.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		f.write(cw, f.name)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP writes the metrics as the response
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// labelKey returns the label pairs of values, it panics if a value is not given for each label
+func labelKey(labels, values []string) string {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: %d label values given for %d labels", len(values), len(labels)))
+	}
+	return labelPairs(labels, values)
+}
+
+func labelPairs(labels, values []string) string {
+	var b strings.Builder
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter.")
+	g := r.NewGauge("test_active", "A gauge\nwith a newline.")
+	r.NewGaugeFunc("test_func", "A gauge func.", func() float64 { return 1.5 })
+	v := r.NewCounterVec("test_by_type_total", "A counter vec.", "type", "code")
+	gv := r.NewGaugeVec("test_active_by_type", "A gauge vec.", "type")
+
+	c.Add(3)
+	c.Inc()
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	v.With("b", "1").Inc()
+	v.With("a", `x"y\`).Add(2)
+	v.With("b", "1").Inc()
+	gv.With("b").Inc()
+	gv.With("a").Inc()
+	gv.With("b").Inc()
+	gv.With("a").Dec()
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	require.Nil(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+	require.Equal(t, `# HELP test_total A counter.
+# TYPE test_total counter
+test_total 4
+# HELP test_active A gauge\nwith a newline.
+# TYPE test_active gauge
+test_active 1
+# HELP test_func A gauge func.
+# TYPE test_func gauge
+test_func 1.5
+# HELP test_by_type_total A counter vec.
+# TYPE test_by_type_total counter
+test_by_type_total{type="a",code="x\"y\\"} 2
+test_by_type_total{type="b",code="1"} 2
+# HELP test_active_by_type A gauge vec.
+# TYPE test_active_by_type gauge
+test_active_by_type{type="a"} 0
+test_active_by_type{type="b"} 2
+`, buf.String())
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "")
+	require.Panics(t, func() { r.NewGauge("test_total", "") })
+}
+
+func TestCounterVec_LabelCount(t *testing.T) {
+	v := NewRegistry().NewCounterVec("test_total", "", "type")
+	require.Panics(t, func() { v.With("a", "b") })
+	gv := NewRegistry().NewGaugeVec("test", "", "type")
+	require.Panics(t, func() { gv.With() })
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("test_inf", "", func() float64 { return math.Inf(1) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	require.Contains(t, w.Body.String(), "test_inf +Inf\n")
+}
@@ -0,0 +1,219 @@
+// Package proxyproto reads the PROXY protocol headers, version 1 and 2, sent by
+// the load balancers in front of a server to pass the addresses of the clients
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// v1MaxLength is the maximum length of a version 1 header including CRLF
+	v1MaxLength = 107
+	// v2HeaderLength is the length of the fixed part of a version 2 header
+	v2HeaderLength = 16
+)
+
+var (
+	v1Prefix    = []byte("PROXY")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+var (
+	// ErrNoHeader occurs when the connection does not start with a PROXY protocol header
+	ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+	// ErrInvalidHeader occurs when the header is malformed
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+)
+
+// Header is a PROXY protocol header
+type Header struct {
+	Version int
+	// Local states that the connection was opened by the proxy itself, e.g. for health checks
+	Local bool
+	// Source and Destination are nil when the addresses are unknown
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadHeader reads the header at the start of r. It reads no byte past the header,
+// so r can be passed to the next protocol afterwards
+func ReadHeader(r io.Reader) (*Header, error) {
+	prefix := make([]byte, len(v1Prefix))
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(prefix, v1Prefix):
+		return readV1(r)
+	case bytes.Equal(prefix, v2Signature[:len(prefix)]):
+		return readV2(r, prefix)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 reads the rest of a version 1 header, e.g. " TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"
+func readV1(r io.Reader) (*Header, error) {
+	line := make([]byte, 0, v1MaxLength-len(v1Prefix))
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == cap(line) {
+			return nil, ErrInvalidHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "" {
+		return nil, ErrInvalidHeader
+	}
+	h := &Header{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if len(fields) != 6 {
+		return nil, ErrInvalidHeader
+	}
+	var err error
+	if h.Source, err = parseV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if h.Destination, err = parseV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseV1Addr(family, ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil || (family == "TCP4") != (addr.IP.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, ErrInvalidHeader
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readV2 reads the rest of a version 2 header, prefix is the part of the signature already read
+func readV2(r io.Reader, prefix []byte) (*Header, error) {
+	buf := make([]byte, v2HeaderLength)
+	copy(buf, prefix)
+	if _, err := io.ReadFull(r, buf[len(prefix):]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(v2Signature)], v2Signature) {
+		return nil, ErrNoHeader
+	}
+	verCmd, family := buf[12], buf[13]
+	if verCmd>>4 != 2 {
+		return nil, ErrInvalidHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	h := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		h.Local = true
+		return h, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidHeader
+	}
+
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		h.Source, h.Destination = v2InetAddrs(family, payload[0:4], payload[4:8], payload[8:12])
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		h.Source, h.Destination = v2InetAddrs(family, payload[0:16], payload[16:32], payload[32:36])
+	case 0x3: // AF_UNIX
+		if len(payload) < 216 {
+			return nil, ErrInvalidHeader
+		}
+		h.Source = &net.UnixAddr{Name: cString(payload[0:108]), Net: "unix"}
+		h.Destination = &net.UnixAddr{Name: cString(payload[108:216]), Net: "unix"}
+	}
+	// the addresses of AF_UNSPEC are unknown, the TLVs following the addresses are ignored
+	return h, nil
+}
+
+func v2InetAddrs(family byte, src, dst, ports []byte) (net.Addr, net.Addr) {
+	srcPort := int(binary.BigEndian.Uint16(ports[0:2]))
+	dstPort := int(binary.BigEndian.Uint16(ports[2:4]))
+	if family&0x0f == 0x2 { // DGRAM
+		return &net.UDPAddr{IP: net.IP(src), Port: srcPort}, &net.UDPAddr{IP: net.IP(dst), Port: dstPort}
+	}
+	return &net.TCPAddr{IP: net.IP(src), Port: srcPort}, &net.TCPAddr{IP: net.IP(dst), Port: dstPort}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// Trusted is the list of the networks allowed to send PROXY protocol headers
+type Trusted []*net.IPNet
+
+// ParseTrusted parses the CIDRs of the trusted networks, a single IP address is accepted as well
+func ParseTrusted(cidrs ...string) (Trusted, error) {
+	t := make(Trusted, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.New("proxyproto: invalid address " + cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			t = append(t, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, ipnet)
+	}
+	return t, nil
+}
+
+// Contains states if addr is in one of the trusted networks, only TCP addresses can be trusted
+func (t Trusted) Contains(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range t {
+		if ipnet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
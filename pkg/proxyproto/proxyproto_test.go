@@ -0,0 +1,114 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeader_V1(t *testing.T) {
+	r := bytes.NewBufferString("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\n")
+	h, err := ReadHeader(r)
+	require.Nil(t, err)
+	require.Equal(t, 1, h.Version)
+	require.Equal(t, "192.0.2.1:56324", h.Source.String())
+	require.Equal(t, "192.0.2.2:443", h.Destination.String())
+	rest, _ := ioutil.ReadAll(r)
+	require.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+
+	h, err = ReadHeader(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"))
+	require.Nil(t, err)
+	require.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	h, err = ReadHeader(bytes.NewBufferString("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	require.Nil(t, err)
+	require.Nil(t, h.Source)
+}
+
+func TestReadHeader_V1Invalid(t *testing.T) {
+	tests := []string{
+		"PROXY TCP4 192.0.2.1 192.0.2.2 56324\r\n",
+		"PROXY TCP4 2001:db8::1 192.0.2.2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 70000 443\r\n",
+		"PROXY TCP4 192.0.2.1 192.0.2.2 01 443\r\n",
+		"PROXY UDP4 192.0.2.1 192.0.2.2 1 443\r\n",
+		"PROXYTCP4 192.0.2.1 192.0.2.2 1 443\r\n",
+		"PROXY " + string(bytes.Repeat([]byte("1"), 120)) + "\r\n",
+	}
+	for _, tt := range tests {
+		_, err := ReadHeader(bytes.NewBufferString(tt))
+		require.Equal(t, ErrInvalidHeader, err, tt)
+	}
+}
+
+func TestReadHeader_NoHeader(t *testing.T) {
+	_, err := ReadHeader(bytes.NewBufferString("GET / HTTP/1.1\r\n"))
+	require.Equal(t, ErrNoHeader, err)
+	_, err = ReadHeader(bytes.NewBufferString("\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00"))
+	require.Equal(t, ErrNoHeader, err)
+}
+
+func v2Header(verCmd, family byte, payload []byte) []byte {
+	buf := append([]byte{}, v2Signature...)
+	buf = append(buf, verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(buf[14:], uint16(len(payload)))
+	return append(buf, payload...)
+}
+
+func TestReadHeader_V2(t *testing.T) {
+	payload := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0xdc, 0x04, 0x01, 0xbb}
+	tlv := []byte{0x04, 0x00, 0x01, 0x00} // NOOP
+	r := bytes.NewBuffer(append(v2Header(0x21, 0x11, append(payload, tlv...)), "GET"...))
+	h, err := ReadHeader(r)
+	require.Nil(t, err)
+	require.Equal(t, 2, h.Version)
+	require.False(t, h.Local)
+	require.Equal(t, "192.0.2.1:56324", h.Source.String())
+	require.Equal(t, "192.0.2.2:443", h.Destination.String())
+	require.Equal(t, "GET", r.String())
+
+	ip6 := make([]byte, 36)
+	copy(ip6, net.ParseIP("2001:db8::1"))
+	copy(ip6[16:], net.ParseIP("2001:db8::2"))
+	ip6[33] = 1
+	h, err = ReadHeader(bytes.NewBuffer(v2Header(0x21, 0x21, ip6)))
+	require.Nil(t, err)
+	require.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	h, err = ReadHeader(bytes.NewBuffer(v2Header(0x20, 0x00, nil)))
+	require.Nil(t, err)
+	require.True(t, h.Local)
+	require.Nil(t, h.Source)
+}
+
+func TestReadHeader_V2Invalid(t *testing.T) {
+	tests := [][]byte{
+		v2Header(0x11, 0x11, make([]byte, 12)), // version 1
+		v2Header(0x22, 0x11, make([]byte, 12)), // unknown command
+		v2Header(0x21, 0x11, make([]byte, 8)),  // short addresses
+		v2Header(0x21, 0x21, make([]byte, 12)),
+	}
+	for _, tt := range tests {
+		_, err := ReadHeader(bytes.NewBuffer(tt))
+		require.Equal(t, ErrInvalidHeader, err)
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8", "192.0.2.1", "2001:db8::/32")
+	require.Nil(t, err)
+	require.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}))
+	require.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	require.False(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("192.0.2.2")}))
+	require.True(t, trusted.Contains(&net.TCPAddr{IP: net.ParseIP("2001:db8::5")}))
+	require.False(t, trusted.Contains(&net.UnixAddr{Name: "/tmp/s", Net: "unix"}))
+
+	_, err = ParseTrusted("10.0.0.0/33")
+	require.NotNil(t, err)
+	_, err = ParseTrusted("example.com")
+	require.NotNil(t, err)
+}
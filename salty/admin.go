@@ -0,0 +1,257 @@
+package salty
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/encoding/hexutil"
+)
+
+// PathInfo describes a path and its clients
+type PathInfo struct {
+	Key        string       `json:"key"`
+	Initiator  bool         `json:"initiator"`  // initiator slot is occupied
+	Responders int          `json:"responders"` // number of the occupied responder slots
+	Clients    []ClientInfo `json:"clients"`
+}
+
+// ClientInfo describes a client. Serial identifies the client in the admin API from its
+// accept on, ID is the slot on the path which is zero until the client is authenticated
+type ClientInfo struct {
+	Serial        uint64  `json:"serial"`
+	ID            uint8   `json:"id"`
+	Role          string  `json:"role"`
+	State         string  `json:"state"`
+	Authenticated bool    `json:"authenticated"`
+	RemoteAddr    string  `json:"remote_addr"`
+	AgeSeconds    float64 `json:"age_seconds"`
+}
+
+// addClient registers client with the next serial, it is listed by the admin API until it is disconnected
+func (s *Server) addClient(client *Client) {
+	s.adminMux.Lock()
+	defer s.adminMux.Unlock()
+	if s.clients == nil {
+		s.clients = make(map[*Client]struct{})
+	}
+	s.serial++
+	client.serial = s.serial
+	s.clients[client] = struct{}{}
+}
+
+func (s *Server) removeClient(client *Client) {
+	s.adminMux.Lock()
+	delete(s.clients, client)
+	s.adminMux.Unlock()
+}
+
+// pathClients returns the registered clients of the path of key, or of all paths if key is empty
+func (s *Server) pathClients(key string) []*Client {
+	key = normalizeKey(key)
+	s.adminMux.Lock()
+	defer s.adminMux.Unlock()
+	var clients []*Client
+	for client := range s.clients {
+		if key == "" || client.Path.key == key {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// Ban makes the server reject the connections to the path of the initiator key
+// with CloseFramePolicyViolation, the clients on the path are closed
+func (s *Server) Ban(key string) {
+	key = normalizeKey(key)
+	s.adminMux.Lock()
+	if s.bans == nil {
+		s.bans = make(map[string]struct{})
+	}
+	s.bans[key] = struct{}{}
+	s.adminMux.Unlock()
+	Sugar.Info("Banned the key: ", key)
+	s.DropPath(key)
+}
+
+// Unban accepts the connections to the path of the initiator key again
+func (s *Server) Unban(key string) {
+	s.adminMux.Lock()
+	delete(s.bans, normalizeKey(key))
+	s.adminMux.Unlock()
+}
+
+// Bans returns the banned initiator keys
+func (s *Server) Bans() []string {
+	s.adminMux.Lock()
+	defer s.adminMux.Unlock()
+	keys := make([]string, 0, len(s.bans))
+	for key := range s.bans {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) banned(key string) bool {
+	s.adminMux.Lock()
+	defer s.adminMux.Unlock()
+	_, ok := s.bans[normalizeKey(key)]
+	return ok
+}
+
+// DropPath closes the clients on the path of key with CloseFramePolicyViolation.
+// It returns the number of the clients closed
+func (s *Server) DropPath(key string) int {
+	clients := s.pathClients(key)
+	for _, client := range clients {
+		submitDisconnect(client.conn, client, CloseFramePolicyViolation)
+	}
+	return len(clients)
+}
+
+// Kick closes the client of serial on the path of key with CloseFramePolicyViolation,
+// the clients still in the handshake included
+func (s *Server) Kick(key string, serial uint64) bool {
+	for _, client := range s.pathClients(key) {
+		if client.serial == serial {
+			submitDisconnect(client.conn, client, CloseFramePolicyViolation)
+			return true
+		}
+	}
+	return false
+}
+
+// PathInfos returns the paths with their clients sorted by key,
+// or only the path of key if it is not empty
+func (s *Server) PathInfos(key string) []PathInfo {
+	byKey := make(map[string]*PathInfo)
+	for _, client := range s.pathClients(key) {
+		info, ok := byKey[client.Path.key]
+		if !ok {
+			info = &PathInfo{Key: client.Path.key, Clients: []ClientInfo{}}
+			_, info.Initiator = client.Path.GetInitiator()
+			info.Responders = client.Path.numResponders()
+			byKey[client.Path.key] = info
+		}
+		info.Clients = append(info.Clients, client.info())
+	}
+
+	infos := make([]PathInfo, 0, len(byKey))
+	for _, info := range byKey {
+		sort.Slice(info.Clients, func(i, j int) bool {
+			return info.Clients[i].AgeSeconds > info.Clients[j].AgeSeconds
+		})
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
+}
+
+func (c *Client) info() ClientInfo {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return ClientInfo{
+		Serial:        c.serial,
+		ID:            c.ID,
+		Role:          c.Role().String(),
+		State:         c.State.String(),
+		Authenticated: c.Authenticated,
+		RemoteAddr:    c.conn.RemoteAddr().String(),
+		AgeSeconds:    time.Since(c.connectedAt).Seconds(),
+	}
+}
+
+// AdminHandler serves the admin API to the requests authorized by the bearer token:
+//
+//	GET    /paths                         lists the paths
+//	GET    /paths/{key}                   shows a path
+//	DELETE /paths/{key}                   drops a path
+//	DELETE /paths/{key}/clients/{serial}  kicks a client
+//	GET    /bans                          lists the banned initiator keys
+//	PUT    /bans/{key}                    bans an initiator key
+//	DELETE /bans/{key}                    unbans an initiator key
+//	GET    /drain                         shows if the server is draining
+//	PUT    /drain                         starts draining the server
+//	DELETE /drain                         stops draining the server
+func (s *Server) AdminHandler(token string) http.Handler {
+	if token == "" {
+		panic("salty: empty admin token")
+	}
+	return &adminHandler{s: s, token: []byte("Bearer " + token)}
+}
+
+type adminHandler struct {
+	s     *Server
+	token []byte
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), h.token) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) > 1 {
+		if err := hexutil.IsValidHexPathString(parts[1]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	route := r.Method + " " + parts[0]
+	switch {
+	case len(parts) == 1 && route == "GET paths":
+		writeJSON(w, h.s.PathInfos(""))
+	case len(parts) == 2 && route == "GET paths":
+		infos := h.s.PathInfos(parts[1])
+		if len(infos) == 0 {
+			http.Error(w, "path not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, infos[0])
+	case len(parts) == 2 && route == "DELETE paths":
+		writeJSON(w, map[string]int{"dropped": h.s.DropPath(parts[1])})
+	case len(parts) == 4 && route == "DELETE paths" && parts[2] == "clients":
+		serial, err := strconv.ParseUint(parts[3], 10, 64)
+		if err != nil || serial == 0 {
+			http.Error(w, "invalid client serial", http.StatusBadRequest)
+			return
+		}
+		if !h.s.Kick(parts[1], serial) {
+			http.Error(w, "client not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && route == "GET bans":
+		writeJSON(w, h.s.Bans())
+	case len(parts) == 2 && route == "PUT bans":
+		h.s.Ban(parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && route == "DELETE bans":
+		h.s.Unban(parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && route == "GET drain":
+		writeJSON(w, map[string]bool{"draining": h.s.Draining()})
+	case len(parts) == 1 && route == "PUT drain":
+		h.s.Drain()
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && route == "DELETE drain":
+		h.s.Undrain()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
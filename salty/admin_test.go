@@ -0,0 +1,137 @@
+package salty
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminHandler_Unauthorized(t *testing.T) {
+	s, _ := newStreamTestServer(t)
+	h := s.AdminHandler("secret")
+	require.Equal(t, http.StatusUnauthorized, adminRequest(t, h, "GET", "/paths", "").Code)
+	require.Equal(t, http.StatusUnauthorized, adminRequest(t, h, "GET", "/paths", "wrong").Code)
+	require.Equal(t, http.StatusOK, adminRequest(t, h, "GET", "/paths", "secret").Code)
+	require.Panics(t, func() { s.AdminHandler("") })
+}
+
+func TestAdminHandler_PathsAndBans(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	h := s.AdminHandler("secret")
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/salty/" + testPathKey
+	rw := dialStream(t, url)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	w := adminRequest(t, h, "GET", "/paths", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var infos []PathInfo
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), &infos))
+	require.Len(t, infos, 1)
+	require.Equal(t, testPathKey, infos[0].Key)
+	require.False(t, infos[0].Initiator)
+	require.Len(t, infos[0].Clients, 1)
+	require.Equal(t, "undetermined", infos[0].Clients[0].Role)
+	require.NotEmpty(t, infos[0].Clients[0].RemoteAddr)
+
+	require.Equal(t, http.StatusNotFound, adminRequest(t, h, "GET", "/paths/"+strings.Repeat("b2", 32), "secret").Code)
+	require.Equal(t, http.StatusBadRequest, adminRequest(t, h, "GET", "/paths/nothex", "secret").Code)
+	require.Equal(t, http.StatusNotFound, adminRequest(t, h, "DELETE", "/paths/"+testPathKey+"/clients/2", "secret").Code)
+	require.Equal(t, http.StatusBadRequest, adminRequest(t, h, "DELETE", "/paths/"+testPathKey+"/clients/0", "secret").Code)
+
+	// banning closes the clients on the path and rejects the new ones
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "PUT", "/bans/"+testPathKey, "secret").Code)
+	requireClosedWith(t, rw, prot.CloseCodePolicyViolation)
+	requireClosedWith(t, dialStream(t, url), prot.CloseCodePolicyViolation)
+
+	w = adminRequest(t, h, "GET", "/bans", "secret")
+	require.Equal(t, "[\""+testPathKey+"\"]\n", w.Body.String())
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "DELETE", "/bans/"+testPathKey, "secret").Code)
+	require.Empty(t, s.Bans())
+}
+
+func TestAdminHandler_KickInHandshake(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	h := s.AdminHandler("secret")
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	// the client has no slot yet, it is kicked by its serial
+	client := s.PathInfos(testPathKey)[0].Clients[0]
+	require.Zero(t, client.ID)
+	require.NotZero(t, client.Serial)
+	path := "/paths/" + testPathKey + "/clients/" + strconv.FormatUint(client.Serial, 10)
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "DELETE", path, "secret").Code)
+	requireClosedWith(t, rw, prot.CloseCodePolicyViolation)
+}
+
+func requireClosedWith(t *testing.T, rw interface {
+	Read([]byte) (int, error)
+}, code int) {
+	frame, err := ws.ReadFrame(rw)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpClose, frame.Header.OpCode)
+	got, _ := ws.ParseCloseFrameData(frame.Payload)
+	require.Equal(t, ws.StatusCode(code), got)
+}
+
+func TestAdminHandler_KeyCase(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	h := s.AdminHandler("secret")
+	upper := strings.ToUpper(testPathKey)
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/salty/"
+
+	// both cases share the path
+	rw := dialStream(t, url+upper)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+	require.Len(t, s.PathInfos(testPathKey), 1)
+	require.Equal(t, testPathKey, s.PathInfos(upper)[0].Key)
+
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "PUT", "/bans/"+testPathKey, "secret").Code)
+	requireClosedWith(t, rw, prot.CloseCodePolicyViolation)
+	requireClosedWith(t, dialStream(t, url+upper), prot.CloseCodePolicyViolation)
+
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "DELETE", "/bans/"+upper, "secret").Code)
+	require.Empty(t, s.Bans())
+	s.Ban(upper)
+	require.Equal(t, []string{testPathKey}, s.Bans())
+	requireClosedWith(t, dialStream(t, url+testPathKey), prot.CloseCodePolicyViolation)
+}
+
+func TestAdminHandler_Drain(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	h := s.AdminHandler("secret")
+	url := "ws" + strings.TrimPrefix(hs.URL, "http") + "/salty/" + testPathKey
+
+	require.Equal(t, "{\"draining\":false}\n", adminRequest(t, h, "GET", "/drain", "secret").Body.String())
+
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "PUT", "/drain", "secret").Code)
+	require.True(t, s.Draining())
+	require.Equal(t, "{\"draining\":true}\n", adminRequest(t, h, "GET", "/drain", "secret").Body.String())
+	requireClosedWith(t, dialStream(t, url), prot.CloseCodeTryAgainLater)
+
+	require.Equal(t, http.StatusNoContent, adminRequest(t, h, "DELETE", "/drain", "secret").Code)
+	require.False(t, s.Draining())
+	_, _, err := wsutil.ReadServerData(dialStream(t, url))
+	require.Nil(t, err)
+}
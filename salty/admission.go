@@ -0,0 +1,188 @@
+package salty
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/encoding/hexutil"
+	ws "github.com/gobwas/ws"
+)
+
+// ErrNotAuthorized occurs when the Authorizer rejects a connection
+var ErrNotAuthorized = errors.New("connection is not authorized")
+
+// Admission describes a connection being upgraded
+type Admission struct {
+	PathKey    string // initiator key in lowercase hex
+	RemoteAddr net.Addr
+	Header     http.Header
+	Query      url.Values
+	Grant      Grant // set by the Authorizer to restrict the client
+
+	listener string    // listener label of the metrics
+	accepted time.Time // start of the handshake deadline
+}
+
+// Grant restricts what an admitted client can do on its path
+type Grant struct {
+	// Role is the only role the client can take, RoleUndetermined allows both
+	Role Role
+	// MaxResponders is the number of the responders the path of the client can have.
+	// The smallest one granted to the clients joining the path applies to all of its
+	// responders while the path exists. Zero means no limit
+	MaxResponders int
+}
+
+// Authorizer decides if the connection of an Admission can be upgraded.
+// The upgrade is rejected with 403 Forbidden when Authorize returns an error
+type Authorizer interface {
+	Authorize(a *Admission) error
+}
+
+// AllowAll authorizes every connection, it is the default Authorizer
+type AllowAll struct{}
+
+// Authorize returns nil
+func (AllowAll) Authorize(*Admission) error {
+	return nil
+}
+
+// Authorizers authorizes the connections authorized by all of its Authorizers, in order
+type Authorizers []Authorizer
+
+// Authorize returns the first error of the Authorizers
+func (as Authorizers) Authorize(a *Admission) error {
+	for _, authorizer := range as {
+		if err := authorizer.Authorize(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyList authorizes the connections by the initiator key of their paths, it
+// allows either only the listed keys or all but the listed keys. Reload replaces the keys
+type KeyList struct {
+	file string
+	deny bool
+	keys atomic.Value // map[string]struct{}
+}
+
+// NewAllowList creates the KeyList allowing only the keys listed in file
+func NewAllowList(file string) (*KeyList, error) {
+	return newKeyList(file, false)
+}
+
+// NewDenyList creates the KeyList denying the keys listed in file
+func NewDenyList(file string) (*KeyList, error) {
+	return newKeyList(file, true)
+}
+
+func newKeyList(file string, deny bool) (*KeyList, error) {
+	l := &KeyList{file: file, deny: deny}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload loads the file again, the current keys are kept on error.
+// The file lists a key in hex per line, empty lines and the ones starting with # are skipped
+func (l *KeyList) Reload() error {
+	f, err := os.Open(l.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := hexutil.IsValidHexPathString(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", l.file, n, err)
+		}
+		keys[normalizeKey(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	l.keys.Store(keys)
+	return nil
+}
+
+// Authorize returns ErrNotAuthorized if the path key is denied
+func (l *KeyList) Authorize(a *Admission) error {
+	_, listed := l.keys.Load().(map[string]struct{})[normalizeKey(a.PathKey)]
+	if listed == l.deny {
+		return ErrNotAuthorized
+	}
+	return nil
+}
+
+// authorize calls the Authorizer of the server, the rejections are logged
+func (s *Server) authorize(a *Admission) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	if err := s.Authorizer.Authorize(a); err != nil {
+		Sugar.Info("Rejecting the connection, key: ", a.PathKey, ", remote: ", a.RemoteAddr, " :", err)
+		s.metrics.unauthorized(a.listener)
+		return err
+	}
+	return nil
+}
+
+// upgrade upgrades conn of the client at remoteAddr to the WebSocket protocol
+// if the Authorizer allows, and returns the Admission of the request. listener
+// is the listener label of the metrics of conn
+func (s *Server) upgrade(conn io.ReadWriter, remoteAddr net.Addr, listener string) (*Admission, error) {
+	a := &Admission{RemoteAddr: remoteAddr, Header: make(http.Header), listener: listener}
+	upgrader := ws.Upgrader{
+		OnRequest: func(uri []byte) error {
+			u, err := url.ParseRequestURI(string(uri))
+			if err != nil {
+				return err
+			}
+			a.PathKey = normalizeKey(strings.TrimPrefix(u.Path, "/"))
+			a.Query = u.Query()
+			return hexutil.IsValidHexPathString(a.PathKey)
+		},
+		OnHost: func(host []byte) error {
+			a.Header.Set("Host", string(host))
+			return nil
+		},
+		OnHeader: func(key, value []byte) error {
+			a.Header.Add(string(key), string(value))
+			return nil
+		},
+		OnBeforeUpgrade: func() (ws.HandshakeHeader, error) {
+			if err := s.authorize(a); err != nil {
+				return nil, ws.RejectConnectionError(ws.RejectionStatus(http.StatusForbidden))
+			}
+			return ws.HandshakeHeaderString(""), nil
+		},
+	}
+	_, err := upgrader.Upgrade(conn)
+	return a, err
+}
+
+// requestAddr returns the remote address of r
+func requestAddr(r *http.Request) net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.UnixAddr{Name: r.RemoteAddr, Net: "unix"}
+}
@@ -0,0 +1,109 @@
+package salty
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyFile(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "keys")
+	require.Nil(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestKeyList(t *testing.T) {
+	other := strings.Repeat("b2", 32)
+	file := writeKeyFile(t, "# provisioned\n\n"+strings.ToUpper(testPathKey)+"\n")
+
+	allow, err := NewAllowList(file)
+	require.Nil(t, err)
+	require.Nil(t, allow.Authorize(&Admission{PathKey: testPathKey}))
+	require.Equal(t, ErrNotAuthorized, allow.Authorize(&Admission{PathKey: other}))
+
+	deny, err := NewDenyList(file)
+	require.Nil(t, err)
+	require.Equal(t, ErrNotAuthorized, deny.Authorize(&Admission{PathKey: testPathKey}))
+	require.Nil(t, deny.Authorize(&Admission{PathKey: other}))
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("nothex\n"), 0600))
+	err = allow.Reload()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), ":1:")
+	require.Nil(t, allow.Authorize(&Admission{PathKey: testPathKey}))
+
+	require.Nil(t, ioutil.WriteFile(file, []byte(other+"\n"), 0600))
+	require.Nil(t, allow.Reload())
+	require.Nil(t, allow.Authorize(&Admission{PathKey: other}))
+	require.Equal(t, ErrNotAuthorized, allow.Authorize(&Admission{PathKey: testPathKey}))
+
+	_, err = NewAllowList(filepath.Join(t.TempDir(), "missing"))
+	require.NotNil(t, err)
+}
+
+type authorizerFunc func(a *Admission) error
+
+func (f authorizerFunc) Authorize(a *Admission) error {
+	return f(a)
+}
+
+func TestUpgrade_Authorizer(t *testing.T) {
+	s, _ := newStreamTestServer(t)
+	var got *Admission
+	s.Authorizer = authorizerFunc(func(a *Admission) error {
+		got = a
+		if a.Query.Get("device") != "provisioned" {
+			return ErrNotAuthorized
+		}
+		return nil
+	})
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"?device=provisioned", http.StatusSwitchingProtocols},
+		{"?device=other", http.StatusForbidden},
+	} {
+		server, client := net.Pipe()
+		remoteAddr := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 41000}
+		go s.upgrade(server, remoteAddr, streamListener)
+
+		req, err := http.NewRequest("GET", "http://salty/"+testPathKey+tc.query, nil)
+		require.Nil(t, err)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("X-Device", "phone")
+		go req.Write(client)
+
+		resp, err := http.ReadResponse(bufio.NewReader(client), req)
+		require.Nil(t, err)
+		require.Equal(t, tc.status, resp.StatusCode)
+		client.Close()
+
+		require.Equal(t, testPathKey, got.PathKey)
+		require.Equal(t, remoteAddr, got.RemoteAddr)
+		require.Equal(t, "phone", got.Header.Get("X-Device"))
+		require.Equal(t, "salty", got.Header.Get("Host"))
+	}
+}
+
+func TestServeHTTP_Authorizer(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	keys, err := NewAllowList(writeKeyFile(t, strings.Repeat("b2", 32)))
+	require.Nil(t, err)
+	s.Authorizer = keys
+
+	resp, err := http.Get(hs.URL + "/salty/" + testPathKey)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
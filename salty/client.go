@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/arrayutil"
 
@@ -13,19 +14,10 @@ import (
 	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
 )
 
-// STATES
-const (
-	None = iota + 1
-	ServerHello
-	ClientHello
-	ClientAuth
-	ServerAuth
-)
-
 // Client ..
 type Client struct {
 	mux  sync.Mutex
-	conn *Conn
+	conn Connection
 
 	ClientKey          [nacl.NaclKeyBytesSize]byte
 	ServerSessionBox   *nacl.BoxKeyPair
@@ -42,11 +34,20 @@ type Client struct {
 	typeHasValue  bool
 	Path          *Path
 	Server        *Server
-	State         int
+	State         State
+
+	pingInterval time.Duration
+	pingTimer    *time.Timer
+	pongPending  bool
+
+	handshakeTimer *time.Timer
+	connectedAt    time.Time
+	serial         uint64 // identifies the client in the admin API
+	grant          Grant
 }
 
 // NewClient ..
-func NewClient(conn *Conn, clientKey [prot.KeyBytesSize]byte, permanentBox, sessionBox *nacl.BoxKeyPair) (*Client, error) {
+func NewClient(conn Connection, clientKey [prot.KeyBytesSize]byte, permanentBox, sessionBox *nacl.BoxKeyPair) (*Client, error) {
 	cookieOut, err := randutil.RandBytes(prot.CookieLength)
 	if err != nil {
 		return nil, err
@@ -63,6 +64,7 @@ func NewClient(conn *Conn, clientKey [prot.KeyBytesSize]byte, permanentBox, sess
 		ServerPermanentBox:        permanentBox,
 		ServerSessionBox:          sessionBox,
 		State:                     None,
+		connectedAt:               time.Now(),
 	}
 	return c, nil
 }
@@ -90,35 +92,60 @@ func (c *Client) Received(b []byte) {
 	msgIncoming, err := c.Unpack(b)
 	if err != nil {
 		Sugar.Warn("Could not unpack received data :", err)
+		c.closeWithError(err)
+		return
+	}
+
+	msgType, _ := prot.TypeOf(msgIncoming)
+	if _, ok := msgIncoming.(*prot.RawMessage); ok {
+		msgType = RelayMessage
+	}
+	c.Server.metrics.messages.With(string(msgType)).Inc()
+	nextState, err := handshakeFSM.Next(c.State, c.Role(), msgType)
+	if err != nil {
+		Sugar.Warn("Received message violates the handshake :", err)
+		c.closeWithError(err)
 		return
 	}
 
 	if msg, ok := msgIncoming.(*prot.ClientHelloMessage); ok {
 		Sugar.Debug("Received client-hello")
-		c.handleClientHello(msg)
+		err = c.handleClientHello(msg)
 
 	} else if msg, ok := msgIncoming.(*prot.ClientAuthMessage); ok {
 		Sugar.Debug("Received client-auth")
 
-		if err := c.handleClientAuth(msg); err == nil {
-			c.Server.wp.Submit(func() {
+		if err = c.handleClientAuth(msg); err == nil {
+			c.Server.submit(func() {
 				Sugar.Debug("Sending server-auth")
+				c.mux.Lock()
+				defer c.mux.Unlock()
 
-				c.sendServerAuth()
+				if err := c.sendServerAuth(); err != nil {
+					Sugar.Warn("Could not send server-auth :", err)
+					c.closeWithError(err)
+				}
 			})
 		}
 	} else if msg, ok := msgIncoming.(*prot.DropResponderMessage); ok {
 		Sugar.Debug("Sending drop-responder")
-		c.handleDropResponder(msg)
+		err = c.handleDropResponder(msg)
 
 	} else if msg, ok := msgIncoming.(*prot.RawMessage); ok {
 		Sugar.Debug("Received RawMessage")
-		c.handleRawMessage(msg)
+		err = c.handleRawMessage(msg)
 
 	} else {
 		Sugar.Warn("Received unhandled message :", msgIncoming)
+		err = prot.NewMessageFlowError("Unexpected message", prot.ErrNotAllowedMessage)
 	}
-	// todo: handle all messages
+
+	if err != nil {
+		Sugar.Warn("Could not handle received message :", err)
+		c.closeWithError(err)
+		return
+	}
+	c.State = nextState
 	return
 }
 
@@ -185,15 +212,23 @@ func (c *Client) Unpack(data []byte) (msg interface{}, err error) {
 	}
 
 	nonce, _ := prot.ExtractNonce(data)
-	decryptedPayload, err1 := prot.DecryptPayload(c.ClientKey, c.ServerSessionBox.Sk, nonce, f.Payload)
-	if err1 == nil {
+	decryptedPayload, errDecrypt := prot.DecryptPayload(c.ClientKey, c.ServerSessionBox.Sk, nonce, f.Payload)
+	if errDecrypt == nil {
 		f.Payload = decryptedPayload
 	}
 
 	if msg, err = prot.UnmarshalMessage(f); err != nil {
+		if errDecrypt != nil {
+			err = prot.NewMessageFlowError("Could not decrypt payload", errDecrypt)
+		}
 		return
 	}
 
+	// only the messages allowed in the current state can be received unencrypted
+	if msgType, _ := prot.TypeOf(msg); errDecrypt != nil && !handshakeFSM.AllowsPlaintext(c.State, msgType) {
+		return nil, prot.NewMessageFlowError(fmt.Sprintf("Message %s must be encrypted", msgType), errDecrypt)
+	}
+
 	if csnIn == nil {
 		c.CombinedSequenceNumberIn = csn
 	}
@@ -202,18 +237,89 @@ func (c *Client) Unpack(data []byte) (msg interface{}, err error) {
 	return
 }
 
+// Role returns the role of the client
+func (c *Client) Role() Role {
+	t, ok := c.GetType()
+	if !ok {
+		return RoleUndetermined
+	}
+	if t == prot.Initiator {
+		return RoleInitiator
+	}
+	return RoleResponder
+}
+
 // DelFromPath ..
 func (c *Client) DelFromPath() {
-	if c.Authenticated {
+	if c.InPath() {
 		c.Path.Del(c.ID)
 	}
 }
 
+// InPath states if the client is authenticated and still holds its slot on the path
+func (c *Client) InPath() bool {
+	if !c.Authenticated {
+		return false
+	}
+	v, ok := c.Path.Get(c.ID)
+	return ok && v == c
+}
+
+// startHandshakeTimer closes the connection with CloseFrameTimeout unless
+// the client gets authenticated until deadline
+func (c *Client) startHandshakeTimer(deadline time.Time) {
+	c.handshakeTimer = time.AfterFunc(time.Until(deadline), func() {
+		c.Server.submit(func() {
+			c.mux.Lock()
+			defer c.mux.Unlock()
+
+			if c.conn.Closed() || c.State == ServerAuth {
+				return
+			}
+			Sugar.Info("Closing due to handshake timeout, state: ", c.State)
+			c.disconnect(CloseFrameTimeout)
+		})
+	})
+}
+
+// stopHandshakeTimer ..
+func (c *Client) stopHandshakeTimer() {
+	if c.handshakeTimer != nil {
+		c.handshakeTimer.Stop()
+	}
+}
+
+// closeWithError closes the connection with the close code that err maps to
+func (c *Client) closeWithError(err error) {
+	code := CloseCodeOf(err)
+	Sugar.Info("Closing with close code: ", code)
+	c.disconnect(getCloseFrameByCode(code, CloseFrameInternalError))
+}
+
+// disconnect closes the connection with closeFrame, notifies the other clients
+// on the path and removes the client from its path
+func (c *Client) disconnect(closeFrame []byte) {
+	c.stopHandshakeTimer()
+	c.stopPinging()
+	if err := c.conn.Close(closeFrame); err != nil {
+		return
+	}
+	c.Server.metrics.disconnected(c, closeFrame)
+	c.Server.removeClient(c)
+	c.Server.Hooks.disconnect(c, closeFrame)
+	c.Disconnected()
+	c.DelFromPath()
+	c.Server.leavePath(c.Path)
+}
+
 // Disconnected ..
 func (c *Client) Disconnected() (err error) {
 	if !c.Authenticated {
 		return errors.New("Client is not authenticated")
 	}
+	if !c.InPath() {
+		return errors.New("Client does not hold its slot on the path anymore")
+	}
 	if c.typeValue == prot.Initiator {
 		iterOnAuthenticatedResponders(c.Path, func(r *Client) {
 			// TODO(oergin): consider to send 'disconnected' message by a new worker
@@ -248,11 +354,7 @@ func (c *Client) sendServerHello() (err error) {
 		c.State = ServerHello
 		return
 	}
-	c.conn.Close(nil)
-	c.DelFromPath()
-	if c.Path.slots.Len() == 0 {
-		c.Server.paths.hmap.Del(c.Path.key)
-	}
+	c.disconnect(nil)
 	return
 }
 
@@ -309,6 +411,24 @@ func (c *Client) sendDisconnected(id uint8) (err error) {
 	return
 }
 
+func (c *Client) sendSendError(messageID []byte) (err error) {
+	Sugar.Debug("sending send-error")
+	msg := prot.NewSendErrorMessage(prot.Server, c.ID, messageID)
+	h, err := c.getHeader(msg.Dest)
+	if err != nil {
+		return
+	}
+	msg.EncodingOpts = c.basicEncodingOpts(h)
+
+	data, err := c.Pack(h, msg)
+	if err != nil {
+		return
+	}
+
+	err = c.Server.WriteCtrl(c.conn, data)
+	return
+}
+
 func (c *Client) sendServerAuth() (err error) {
 	Sugar.Debug("sending server-auth")
 	var msg *prot.ServerAuthMessage
@@ -330,14 +450,26 @@ func (c *Client) sendServerAuth() (err error) {
 			return
 		}
 
-		if prevClient, ok := c.Path.GetInitiator(); ok && prevClient != c {
-			// todo: kill prevClient
-		}
-
+		prevClient, hasPrevClient := c.Path.GetInitiator()
 		c.Path.SetInitiator(c)
+		if hasPrevClient && prevClient != c {
+			// the previous initiator does not hold the slot anymore,
+			// so that dropping it does not notify the responders
+			c.Server.submit(func() {
+				prevClient.mux.Lock()
+				defer prevClient.mux.Unlock()
+
+				Sugar.Info("Dropping previous initiator")
+				prevClient.disconnect(CloseFrameDropByInitiator)
+			})
+		}
 		c.ID = prot.Initiator
 		c.Authenticated = true
 		c.State = ServerAuth
+		c.stopHandshakeTimer()
+		c.startPinging()
+		c.Server.metrics.authenticated(c)
+		c.Server.Hooks.handshakeComplete(c)
 
 		Sugar.Debug("New authenticated Initiator: ", prot.Initiator)
 
@@ -351,7 +483,6 @@ func (c *Client) sendServerAuth() (err error) {
 	slotID, err := c.Path.AddResponder(c)
 	if err != nil {
 		err = fmt.Errorf("Could not allocate Id for responder : %w", err)
-		c.conn.Close(CloseFramePathFullError)
 		return
 	}
 	clientInit, initiatorConnected := c.Path.GetInitiator()
@@ -374,6 +505,10 @@ func (c *Client) sendServerAuth() (err error) {
 	c.ID = slotID
 	c.Authenticated = true
 	c.State = ServerAuth
+	c.stopHandshakeTimer()
+	c.startPinging()
+	c.Server.metrics.authenticated(c)
+	c.Server.Hooks.handshakeComplete(c)
 	Sugar.Debug("New authenticated Responder: ", slotID)
 
 	if initiator, ok := c.Path.GetInitiator(); ok && initiator.Authenticated {
@@ -390,18 +525,15 @@ func (c *Client) sendRawData(data []byte) (err error) {
 
 func (c *Client) handleClientHello(msg *prot.ClientHelloMessage) (err error) {
 	Sugar.Debug("handling client-hello")
-	_, hasType := c.GetType()
-	if hasType {
-		err = errors.New("client already has type")
+	if !nacl.IsValidBoxPkBytes(msg.ClientPublicKey) {
+		err = ErrInvalidClientKey
 		return
 	}
-	if !nacl.IsValidBoxPkBytes(msg.ClientPublicKey) {
-		err = errors.New("invalid client public key length")
+	if err = c.checkRole(RoleResponder); err != nil {
 		return
 	}
 	copy(c.ClientKey[:], msg.ClientPublicKey[0:prot.KeyBytesSize])
 	c.SetType(prot.Responder)
-	c.State = ClientHello
 	return
 }
 
@@ -409,72 +541,97 @@ func (c *Client) handleClientAuth(msg *prot.ClientAuthMessage) (err error) {
 	Sugar.Debug("handling client-auth")
 	// validate your_cookie with cookieOut
 	if !bytes.Equal(msg.ServerCookie, c.CookieOut) {
-		err = errors.New("Cookies do not match")
+		err = ErrCookiesNotMatched
 		return
 	}
 
 	presentSubprotocols := arrayutil.IntersectionStr(msg.Subprotocols, c.Server.subprotocols)
 	if len(presentSubprotocols) == 0 || presentSubprotocols[0] != c.Server.subprotocol {
-		err = errors.New("Invalid subprotocol")
+		err = ErrInvalidSubprotocol
 		return
 	}
 
 	if len(c.Server.permanentBoxes) == 0 {
-		err = errors.New("server does not have a permanent key pair")
+		err = ErrNoPermanentKey
 		return
 	}
 
+	var serverPermanentBox *nacl.BoxKeyPair
 	for _, box := range c.Server.permanentBoxes {
 		if box.PkEqualTo(msg.ServerKey) {
 			// select server permanent box for further use
-			c.ServerPermanentBox = box.Clone()
+			serverPermanentBox = box.Clone()
 			break
 		}
 	}
-	if c.ServerPermanentBox == nil {
-		err = errors.New("yourKey matches none of permanent key pairs of server")
+	if serverPermanentBox == nil {
+		err = ErrInvalidServerKey
 		return
 	}
+	c.ServerPermanentBox = serverPermanentBox
 
-	// todo impl. ping(ing) logic
+	c.pingInterval = time.Duration(msg.PingInterval) * time.Second
 
 	// ServerHello->ClientAuth transition states the method below for initiator handshake
 	if c.State == ServerHello {
+		if err = c.checkRole(RoleInitiator); err != nil {
+			return
+		}
 		c.SetType(prot.Initiator)
 	}
-	c.State = ClientAuth
 	return
 }
 
+// checkRole returns ErrRoleNotGranted if the grant of the client does not allow role
+func (c *Client) checkRole(role Role) error {
+	if c.grant.Role != RoleUndetermined && c.grant.Role != role {
+		return ErrRoleNotGranted
+	}
+	return nil
+}
+
 func (c *Client) handleDropResponder(msg *prot.DropResponderMessage) (err error) {
 	Sugar.Debug("handling drop-responder")
-	if !c.Authenticated || c.typeValue != prot.Initiator {
-		err = errors.New("Client is not authenticated, nor initiator")
-		return
-	}
 	responder, ok := c.Path.Get(msg.ResponderID)
 	if !ok {
-		err = errors.New("Responder does not exist on the path")
+		// dropping a responder which is not on the path is ignored
+		Sugar.Debug("Responder does not exist on the path: ", msg.ResponderID)
 		return
 	}
 	c.Path.Del(msg.ResponderID)
 	closeFrame := getCloseFrameByCode(msg.Reason, CloseFrameDropByInitiator)
-	responder.conn.Close(closeFrame)
+	c.Server.Hooks.dropResponder(c.Path.key, msg.ResponderID, closeFrameCode(closeFrame))
+	c.Server.submit(func() {
+		responder.mux.Lock()
+		defer responder.mux.Unlock()
+		responder.disconnect(closeFrame)
+	})
 	return
 }
 
 func (c *Client) handleRawMessage(msg *prot.RawMessage) (err error) {
 	Sugar.Debug("handling raw-message")
-	if !c.Authenticated || c.ID != msg.Src || msg.Src == msg.Dest {
-		err = errors.New("Client is not authenticated nor valid raw message")
+	if c.ID != msg.Src || msg.Src == msg.Dest {
+		err = prot.NewMessageFlowError("Not a valid raw message", prot.ErrNotAllowedMessage)
 		return
 	}
+	var errRelay error
 	destClient, ok := c.Path.Get(msg.Dest)
-	if !ok {
-		err = errors.New("Dest client does not exist")
-		return
+	if !ok || !destClient.Authenticated {
+		errRelay = errors.New("Dest client does not exist")
+	} else if errRelay = destClient.sendRawData(msg.Data); errRelay == nil {
+		c.Server.metrics.relayedMessages.Inc()
+		c.Server.metrics.relayedBytes.Add(uint64(len(msg.Data)))
+		c.Server.Hooks.relay(c.Path.key, msg.Src, msg.Dest, len(msg.Data))
+	}
+	if errRelay != nil {
+		// relaying failed, notify the sender with the id of the message
+		Sugar.Debug("Could not relay message: ", errRelay)
+		messageID, _ := prot.ExtractMessageID(msg.Data)
+		if errInner := c.sendSendError(messageID); errInner != nil {
+			return fmt.Errorf("error occurred when sending send-error message: %w", errInner)
+		}
 	}
-	err = destClient.sendRawData(msg.Data)
 	return
 }
 
@@ -517,12 +674,12 @@ func iterOnAuthenticatedResponders(p *Path, handler func(c *Client)) {
 func (c *Client) checkCookieIn(cookie []byte) (err error) {
 	if c.cookieIn == nil {
 		if bytes.Equal(cookie, c.CookieOut) {
-			return errors.New("Server and client cookies could not be the same")
+			return ErrSameCookies
 		}
 		return nil
 	}
 	if !bytes.Equal(c.cookieIn, cookie) {
-		return errors.New("Client cookieIn can not change")
+		return ErrCookieInChanged
 	}
 	return nil
 }
@@ -0,0 +1,276 @@
+package salty
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+)
+
+// testPeer is a SaltyRTC client talking to the server in the tests
+type testPeer struct {
+	t   *testing.T
+	rw  io.ReadWriter
+	box *nacl.BoxKeyPair
+	id  prot.AddressType
+
+	cookie          []byte
+	csn             map[prot.AddressType]uint64
+	serverCookie    []byte
+	serverSessionPk [prot.KeyBytesSize]byte
+}
+
+// newTestPeer generates the permanent key pair of a peer
+func newTestPeer(t *testing.T) *testPeer {
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	cookie := make([]byte, prot.CookieLength)
+	cookie[0] = 0xc0
+	return &testPeer{t: t, box: box, cookie: cookie, csn: map[prot.AddressType]uint64{}}
+}
+
+// pathKey returns the path of the peer as an initiator
+func (p *testPeer) pathKey() string {
+	return hex.EncodeToString(p.box.Pk[:])
+}
+
+// connect dials the path of key on the stream test server at url and reads server-hello
+func (p *testPeer) connect(url, key string) *testPeer {
+	p.rw = dialStream(p.t, "ws"+strings.TrimPrefix(url, "http")+"/salty/"+key)
+	return p.serverHello()
+}
+
+// connectLoop dials the path of key on the loop test server at sock and reads server-hello
+func (p *testPeer) connectLoop(sock, key string) *testPeer {
+	p.rw = dialLoop(p.t, sock, key)
+	return p.serverHello()
+}
+
+// serverHello reads server-hello and keeps the cookie and the session key of the server
+func (p *testPeer) serverHello() *testPeer {
+	data, _, err := wsutil.ReadServerData(p.rw)
+	require.Nil(p.t, err)
+	f, err := prot.ParseFrame(data)
+	require.Nil(p.t, err)
+	var hello struct {
+		Type string `codec:"type"`
+		Key  []byte `codec:"key"`
+	}
+	require.Nil(p.t, prot.DecodePayload(f.Payload, &hello))
+	require.Equal(p.t, string(prot.ServerHello), hello.Type)
+	p.serverCookie = f.Header.Cookie
+	copy(p.serverSessionPk[:], hello.Key)
+	return p
+}
+
+// header returns the next header of a message to dest, the sequence numbers are counted by destination
+func (p *testPeer) header(dest prot.AddressType) prot.Header {
+	p.csn[dest]++
+	csn := make([]byte, 8)
+	binary.BigEndian.PutUint64(csn, p.csn[dest])
+	return prot.Header{Cookie: p.cookie, Csn: csn[2:], Src: p.id, Dest: dest}
+}
+
+// send writes the frame of h and payload
+func (p *testPeer) send(h prot.Header, payload []byte) {
+	require.Nil(p.t, wsutil.WriteClientBinary(p.rw, append(prot.MakeNonce(h), payload...)))
+}
+
+// sendToServer encodes payload, encrypts it unless plaintext is set and sends it to the server
+func (p *testPeer) sendToServer(payload interface{}, plaintext bool) {
+	h := p.header(prot.Server)
+	data, err := prot.EncodePayload(payload)
+	require.Nil(p.t, err)
+	if !plaintext {
+		data, err = prot.EncryptPayload(p.serverSessionPk, p.box.Sk, prot.MakeNonce(h), data)
+		require.Nil(p.t, err)
+	}
+	p.send(h, data)
+}
+
+// receive reads the next message, the ones from the server are decrypted into a map
+func (p *testPeer) receive() (prot.Header, map[string]interface{}, []byte) {
+	data, _, err := wsutil.ReadServerData(p.rw)
+	require.Nil(p.t, err)
+	f, err := prot.ParseFrame(data)
+	require.Nil(p.t, err)
+	if f.Header.Src != prot.Server {
+		return f.Header, nil, data
+	}
+	payload, err := prot.DecryptPayload(p.serverSessionPk, p.box.Sk, data[:prot.NonceLength], f.Payload)
+	require.Nil(p.t, err)
+	msg := map[string]interface{}{}
+	require.Nil(p.t, prot.DecodePayload(payload, &msg))
+	return f.Header, msg, data
+}
+
+// requireSilent asserts that no message arrives in a short time
+func (p *testPeer) requireSilent() {
+	conn := p.rw.(interface{ SetReadDeadline(time.Time) error })
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	defer conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := wsutil.ReadServerData(p.rw)
+	netErr, ok := err.(net.Error)
+	require.True(p.t, ok && netErr.Timeout(), "unexpected message or error: %v", err)
+}
+
+// clientAuth returns the client-auth payload of the peer
+func (p *testPeer) clientAuth(serverKey [prot.KeyBytesSize]byte, pingInterval uint32) interface{} {
+	return struct {
+		Type         prot.MessageType `codec:"type"`
+		YourCookie   []byte           `codec:"your_cookie"`
+		Subprotocols []string         `codec:"subprotocols"`
+		PingInterval uint32           `codec:"ping_interval"`
+		YourKey      [32]byte         `codec:"your_key"`
+	}{prot.ClientAuth, p.serverCookie, []string{prot.SubprotocolSaltyRTCv1}, pingInterval, serverKey}
+}
+
+// authInitiator completes the handshake of the peer as the initiator
+func (p *testPeer) authInitiator(s *Server, pingInterval uint32) map[string]interface{} {
+	p.sendToServer(p.clientAuth(s.permanentBoxes[0].Pk, pingInterval), false)
+	h, msg, _ := p.receive()
+	require.Equal(p.t, string(prot.ServerAuth), msg["type"])
+	p.id = h.Dest
+	return msg
+}
+
+// authResponder completes the handshake of the peer as a responder
+func (p *testPeer) authResponder(s *Server, pingInterval uint32) map[string]interface{} {
+	p.sendToServer(struct {
+		Type prot.MessageType `codec:"type"`
+		Key  []byte           `codec:"key"`
+	}{prot.ClientHello, p.box.Pk[:]}, true)
+	p.sendToServer(p.clientAuth(s.permanentBoxes[0].Pk, pingInterval), false)
+	h, msg, _ := p.receive()
+	require.Equal(p.t, string(prot.ServerAuth), msg["type"])
+	p.id = h.Dest
+	return msg
+}
+
+func TestClient_RejectsPlaintextClientAuth(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+
+	initiator.sendToServer(initiator.clientAuth(s.permanentBoxes[0].Pk, 0), true)
+	requireClosedWith(t, initiator.rw, prot.CloseCodeProtocolError)
+	require.Zero(t, s.metrics.messages.With(string(prot.ClientAuth)).Value())
+	require.Zero(t, s.metrics.initiators.Value())
+}
+
+func TestClient_RejectsUndecryptableMessage(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+
+	// encrypted by a key other than the one of the path
+	other := newTestPeer(t)
+	h := initiator.header(prot.Server)
+	data, err := prot.EncodePayload(initiator.clientAuth(s.permanentBoxes[0].Pk, 0))
+	require.Nil(t, err)
+	data, err = prot.EncryptPayload(initiator.serverSessionPk, other.box.Sk, prot.MakeNonce(h), data)
+	require.Nil(t, err)
+	initiator.send(h, data)
+
+	requireClosedWith(t, initiator.rw, prot.CloseCodeProtocolError)
+	require.Zero(t, s.metrics.messages.With(string(prot.ClientAuth)).Value())
+	require.Zero(t, s.metrics.initiators.Value())
+}
+
+func TestClient_RelayToMissingResponder(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+
+	h := initiator.header(0x02)
+	initiator.send(h, []byte("offer"))
+
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.SendError), msg["type"])
+	require.Equal(t, prot.MakeNonce(h)[prot.CookieLength:prot.HeaderSize], msg["id"])
+	require.Len(t, msg["id"], prot.MessageIDLength)
+}
+
+func TestClient_HandshakeTimeout(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	s.HandshakeTimeout = 100 * time.Millisecond
+
+	idle := newTestPeer(t)
+	idle.connect(hs.URL, idle.pathKey())
+	requireClosedWith(t, idle.rw, prot.CloseCodeTimeout)
+
+	// the timer is stopped by the authentication
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+	time.Sleep(3 * s.HandshakeTimeout)
+	initiator.send(initiator.header(0x02), []byte("offer"))
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.SendError), msg["type"])
+}
+
+func TestClient_NewInitiatorDropsPrevious(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	previous := newTestPeer(t)
+	previous.connect(hs.URL, previous.pathKey())
+	previous.authInitiator(s, 0)
+
+	responder := newTestPeer(t)
+	responder.connect(hs.URL, previous.pathKey())
+	responder.authResponder(s, 0)
+	_, msg, _ := previous.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	initiator := newTestPeer(t)
+	initiator.box = previous.box
+	initiator.connect(hs.URL, initiator.pathKey())
+	msg = initiator.authInitiator(s, 0)
+	require.Equal(t, []interface{}{int64(responder.id)}, msg["responders"])
+
+	requireClosedWith(t, previous.rw, prot.CloseCodeDropByInitiator)
+	_, msg, _ = responder.receive()
+	require.Equal(t, string(prot.NewInitiator), msg["type"])
+
+	// dropping the previous initiator keeps the slot of the new one
+	h := initiator.header(responder.id)
+	initiator.send(h, []byte("offer"))
+	got, _, _ := responder.receive()
+	require.Equal(t, h, got)
+
+	// neither a second new-initiator nor a disconnected of the previous initiator follows
+	responder.requireSilent()
+	initiator.requireSilent()
+}
+
+func TestClient_PathKeptForRespondersInHandshake(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	previous := newTestPeer(t)
+	previous.connect(hs.URL, previous.pathKey())
+	previous.authInitiator(s, 0)
+
+	// the responder has no slot until it is authenticated
+	responder := newTestPeer(t)
+	responder.connect(hs.URL, previous.pathKey())
+
+	require.Nil(t, wsutil.WriteClientMessage(previous.rw, ws.OpClose, ws.NewCloseFrameBody(ws.StatusNormalClosure, "")))
+	require.Eventually(t, func() bool {
+		return len(s.pathClients(previous.pathKey())) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	responder.authResponder(s, 0)
+	initiator := newTestPeer(t)
+	initiator.box = previous.box
+	initiator.connect(hs.URL, initiator.pathKey())
+	msg := initiator.authInitiator(s, 0)
+	require.Equal(t, []interface{}{int64(responder.id)}, msg["responders"])
+}
@@ -9,37 +9,71 @@ import (
 var CloseFrameNormalClosure = compileCloseFrame(prot.CloseCodeNormalClosure, "")
 
 // CloseFrameGoingAway //
-var CloseFrameGoingAway = compileCloseFrame(prot.CloseCodeGoingAway, "")
+var CloseFrameGoingAway = compileCloseFrame(prot.CloseCodeGoingAway, "Going Away")
 
 // CloseFrameSubprotocolError //
-var CloseFrameSubprotocolError = compileCloseFrame(prot.CloseCodeSubprotocolError, "")
+var CloseFrameSubprotocolError = compileCloseFrame(prot.CloseCodeSubprotocolError, "Protocol Error")
+
+// CloseFramePolicyViolation is sent to the clients kicked or banned by an administrator,
+// and to the ones taking a role they are not granted
+var CloseFramePolicyViolation = compileCloseFrame(prot.CloseCodePolicyViolation, "Policy Violation")
+
+// CloseFrameMessageTooBig is sent to the clients sending a message longer than the maximum message size
+var CloseFrameMessageTooBig = compileCloseFrame(prot.CloseCodeMessageTooBig, "Message Too Big")
+
+// CloseFrameTryAgainLater //
+var CloseFrameTryAgainLater = compileCloseFrame(prot.CloseCodeTryAgainLater, "Try Again Later")
 
 // CloseFramePathFullError //
-var CloseFramePathFullError = compileCloseFrame(prot.CloseCodePathFullError, "")
+var CloseFramePathFullError = compileCloseFrame(prot.CloseCodePathFullError, "Path Full")
 
 // CloseFrameProtocolError //
-var CloseFrameProtocolError = compileCloseFrame(prot.CloseCodeProtocolError, "")
+var CloseFrameProtocolError = compileCloseFrame(prot.CloseCodeProtocolError, "Protocol Error")
 
 // CloseFrameInternalError //
-var CloseFrameInternalError = compileCloseFrame(prot.CloseCodeInternalError, "")
+var CloseFrameInternalError = compileCloseFrame(prot.CloseCodeInternalError, "Internal Error")
 
 // CloseFrameHandover //
-var CloseFrameHandover = compileCloseFrame(prot.CloseCodeHandover, "")
+var CloseFrameHandover = compileCloseFrame(prot.CloseCodeHandover, "Handover of the Signalling Channel")
 
 // CloseFrameDropByInitiator //
-var CloseFrameDropByInitiator = compileCloseFrame(prot.CloseCodeDropByInitiator, "")
+var CloseFrameDropByInitiator = compileCloseFrame(prot.CloseCodeDropByInitiator, "Dropped by Initiator")
 
 // CloseFrameInitiatorCouldNotDecrypt //
-var CloseFrameInitiatorCouldNotDecrypt = compileCloseFrame(prot.CloseCodeInitiatorCouldNotDecrypt, "")
+var CloseFrameInitiatorCouldNotDecrypt = compileCloseFrame(prot.CloseCodeInitiatorCouldNotDecrypt, "Initiator Could Not Decrypt")
 
 // CloseFrameNoSharedTasks //
-var CloseFrameNoSharedTasks = compileCloseFrame(prot.CloseCodeNoSharedTasks, "")
+var CloseFrameNoSharedTasks = compileCloseFrame(prot.CloseCodeNoSharedTasks, "No Shared Task Found")
 
 // CloseFrameInvalidKey //
-var CloseFrameInvalidKey = compileCloseFrame(prot.CloseCodeInvalidKey, "")
+var CloseFrameInvalidKey = compileCloseFrame(prot.CloseCodeInvalidKey, "Invalid Key")
 
 // CloseFrameTimeout //
-var CloseFrameTimeout = compileCloseFrame(prot.CloseCodeTimeout, "")
+var CloseFrameTimeout = compileCloseFrame(prot.CloseCodeTimeout, "Timeout")
+
+// CloseFrameSlowConsumer is sent to the clients which do not read their messages in time
+var CloseFrameSlowConsumer = compileCloseFrame(prot.CloseCodeSlowConsumer, "Slow Consumer")
+
+// errCloseDataTooShort occurs when the payload of a close frame has a partial status code
+var errCloseDataTooShort = ws.ProtocolError("close frame payload is too short")
+
+// closeReply returns the close frame answering the close frame payload received from a client.
+// The status code of the client is echoed, an invalid payload is answered with a protocol error
+func closeReply(payload []byte) (reply []byte, err error) {
+	if len(payload) == 0 {
+		return ws.MustCompileFrame(ws.NewCloseFrame(nil)), nil
+	}
+	code, reason := ws.ParseCloseFrameData(payload)
+	if len(payload) < 2 {
+		err = errCloseDataTooShort
+	} else {
+		err = ws.CheckCloseFrameData(code, reason)
+	}
+	if err != nil {
+		return compileCloseFrame(int(ws.StatusProtocolError), ""), err
+	}
+	return compileCloseFrame(int(code), ""), nil
+}
 
 func getCloseFrameByCode(code int, defaultFrame []byte) (closeFrame []byte) {
 	switch code {
@@ -52,6 +86,15 @@ func getCloseFrameByCode(code int, defaultFrame []byte) (closeFrame []byte) {
 	case prot.CloseCodeSubprotocolError:
 		closeFrame = CloseFrameSubprotocolError
 		break
+	case prot.CloseCodePolicyViolation:
+		closeFrame = CloseFramePolicyViolation
+		break
+	case prot.CloseCodeMessageTooBig:
+		closeFrame = CloseFrameMessageTooBig
+		break
+	case prot.CloseCodeTryAgainLater:
+		closeFrame = CloseFrameTryAgainLater
+		break
 	case prot.CloseCodePathFullError:
 		closeFrame = CloseFramePathFullError
 		break
@@ -68,7 +111,7 @@ func getCloseFrameByCode(code int, defaultFrame []byte) (closeFrame []byte) {
 		closeFrame = CloseFrameDropByInitiator
 		break
 	case prot.CloseCodeInitiatorCouldNotDecrypt:
-		closeFrame = CloseFrameSubprotocolError
+		closeFrame = CloseFrameInitiatorCouldNotDecrypt
 		break
 	case prot.CloseCodeNoSharedTasks:
 		closeFrame = CloseFrameNoSharedTasks
@@ -79,16 +122,20 @@ func getCloseFrameByCode(code int, defaultFrame []byte) (closeFrame []byte) {
 	case prot.CloseCodeTimeout:
 		closeFrame = CloseFrameTimeout
 		break
+	case prot.CloseCodeSlowConsumer:
+		closeFrame = CloseFrameSlowConsumer
+		break
 	default:
 		closeFrame = defaultFrame
 	}
 	return
 }
 
+// compileCloseFrame compiles a close frame with code and an optional reason
 func compileCloseFrame(code int, reason string) []byte {
 	return ws.MustCompileFrame(
 		ws.NewCloseFrame(ws.NewCloseFrameBody(
-			ws.StatusAbnormalClosure, reason,
+			ws.StatusCode(code), reason,
 		)),
 	)
 }
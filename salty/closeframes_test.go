@@ -0,0 +1,126 @@
+package salty
+
+import (
+	"bytes"
+	"testing"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseFrames(t *testing.T) {
+	tests := []struct {
+		frame  []byte
+		code   int
+		reason string
+	}{
+		{CloseFrameNormalClosure, prot.CloseCodeNormalClosure, ""},
+		{CloseFrameGoingAway, prot.CloseCodeGoingAway, "Going Away"},
+		{CloseFrameSubprotocolError, prot.CloseCodeSubprotocolError, "Protocol Error"},
+		{CloseFramePolicyViolation, prot.CloseCodePolicyViolation, "Policy Violation"},
+		{CloseFrameMessageTooBig, prot.CloseCodeMessageTooBig, "Message Too Big"},
+		{CloseFrameTryAgainLater, prot.CloseCodeTryAgainLater, "Try Again Later"},
+		{CloseFramePathFullError, prot.CloseCodePathFullError, "Path Full"},
+		{CloseFrameProtocolError, prot.CloseCodeProtocolError, "Protocol Error"},
+		{CloseFrameInternalError, prot.CloseCodeInternalError, "Internal Error"},
+		{CloseFrameHandover, prot.CloseCodeHandover, "Handover of the Signalling Channel"},
+		{CloseFrameDropByInitiator, prot.CloseCodeDropByInitiator, "Dropped by Initiator"},
+		{CloseFrameInitiatorCouldNotDecrypt, prot.CloseCodeInitiatorCouldNotDecrypt, "Initiator Could Not Decrypt"},
+		{CloseFrameNoSharedTasks, prot.CloseCodeNoSharedTasks, "No Shared Task Found"},
+		{CloseFrameInvalidKey, prot.CloseCodeInvalidKey, "Invalid Key"},
+		{CloseFrameTimeout, prot.CloseCodeTimeout, "Timeout"},
+		{CloseFrameSlowConsumer, prot.CloseCodeSlowConsumer, "Slow Consumer"},
+	}
+	for _, tt := range tests {
+		code, reason := decodeCloseFrame(t, tt.frame)
+		require.Equal(t, tt.code, code)
+		require.Equal(t, tt.reason, reason)
+	}
+}
+
+func TestGetCloseFrameByCode(t *testing.T) {
+	codes := []int{
+		prot.CloseCodeNormalClosure,
+		prot.CloseCodeGoingAway,
+		prot.CloseCodeSubprotocolError,
+		prot.CloseCodePolicyViolation,
+		prot.CloseCodeMessageTooBig,
+		prot.CloseCodeTryAgainLater,
+		prot.CloseCodePathFullError,
+		prot.CloseCodeProtocolError,
+		prot.CloseCodeInternalError,
+		prot.CloseCodeHandover,
+		prot.CloseCodeDropByInitiator,
+		prot.CloseCodeInitiatorCouldNotDecrypt,
+		prot.CloseCodeNoSharedTasks,
+		prot.CloseCodeInvalidKey,
+		prot.CloseCodeTimeout,
+		prot.CloseCodeSlowConsumer,
+	}
+	for _, want := range codes {
+		got, _ := decodeCloseFrame(t, getCloseFrameByCode(want, nil))
+		require.Equal(t, want, got)
+	}
+}
+
+func TestGetCloseFrameByCode_Default(t *testing.T) {
+	require := require.New(t)
+
+	got := getCloseFrameByCode(4999, CloseFrameInternalError)
+
+	require.Equal(CloseFrameInternalError, got)
+}
+
+func TestCloseReply(t *testing.T) {
+	require := require.New(t)
+
+	payload := ws.NewCloseFrameBody(ws.StatusGoingAway, "bye")
+	reply, err := closeReply(payload)
+	require.Nil(err)
+	code, reason := decodeCloseFrame(t, reply)
+	require.Equal(int(ws.StatusGoingAway), code)
+	require.Equal("", reason)
+
+	payload = ws.NewCloseFrameBody(prot.CloseCodeHandover, "")
+	reply, err = closeReply(payload)
+	require.Nil(err)
+	code, _ = decodeCloseFrame(t, reply)
+	require.Equal(prot.CloseCodeHandover, code)
+}
+
+func TestCloseReply_Empty(t *testing.T) {
+	require := require.New(t)
+
+	reply, err := closeReply(nil)
+	require.Nil(err)
+	f, err := ws.ReadFrame(bytes.NewReader(reply))
+	require.Nil(err)
+	require.Equal(ws.OpClose, f.Header.OpCode)
+	require.Empty(f.Payload)
+}
+
+func TestCloseReply_Invalid(t *testing.T) {
+	payloads := [][]byte{
+		{0x03},
+		ws.NewCloseFrameBody(ws.StatusNoStatusRcvd, ""),
+		ws.NewCloseFrameBody(999, ""),
+		ws.NewCloseFrameBody(ws.StatusNormalClosure, "\xff"),
+	}
+	for _, payload := range payloads {
+		reply, err := closeReply(payload)
+		require.NotNil(t, err)
+		code, _ := decodeCloseFrame(t, reply)
+		require.Equal(t, int(ws.StatusProtocolError), code)
+	}
+}
+
+func decodeCloseFrame(t *testing.T, frame []byte) (int, string) {
+	f, err := ws.ReadFrame(bytes.NewReader(frame))
+	require.Nil(t, err)
+	require.Equal(t, ws.OpClose, f.Header.OpCode)
+	require.True(t, f.Header.Fin)
+
+	code, reason := ws.ParseCloseFrameData(f.Payload)
+	return int(code), reason
+}
@@ -3,43 +3,216 @@ package salty
 import (
 	"errors"
 	"net"
-	"reflect"
+	"sync"
 	"syscall"
+	"time"
 )
 
-// Conn ..
+var (
+	// ErrConnClosed occurs when writing to a closed connection
+	ErrConnClosed = errors.New("connection already closed")
+	// ErrSlowConsumer occurs when the outbound queue of a connection exceeds its limit
+	ErrSlowConsumer = errors.New("outbound queue limit exceeded")
+)
+
+// Connection is the transport of a client. It is either a Conn served by the event loops,
+// or a connection served by its own goroutines through ServeConn or ServeHTTP
+type Connection interface {
+	// Send queues the compiled frame bts to be written
+	Send(bts []byte) error
+	// Close closes the connection after the queued frames and preWrite are written
+	Close(preWrite []byte) error
+	// Closed states if the connection is closed or being closed
+	Closed() bool
+	// RemoteAddr returns the remote address of the connection
+	RemoteAddr() net.Addr
+}
+
+// Conn is a connection served by the event loops
 type Conn struct {
 	fd         int              // file descriptor
 	sa         syscall.Sockaddr // remote socket address
 	opened     bool             // connection opened event fired
 	addrIndex  int              // index of listening address
 	remoteAddr net.Addr         // remote addr
+	accepted   time.Time        // start of the handshake deadline
 	loop       *loop            // connected loop
 	netConn    net.Conn
 	rawConn    syscall.RawConn
 	upgraded   bool // upgraded to ws protocol
+	upgrading  bool // upgrade goroutine started, accessed by the loop only
+	proxied    bool // PROXY protocol header read if required
 	client     *Client
 	closed     bool
+	reader     *frameReader // incremental reader of ws frames
+	tls        *tlsState    // TLS layer of the connection, nil for plain connections
+
+	wmux      sync.Mutex
+	outbuf    []byte      // outbound queue, flushed by the loop
+	frames    []int       // lengths of the frames in outbuf of plain connections
+	headSent  int         // bytes of the first frame in frames already written
+	maxOut    int         // limit of the outbound queue in bytes, zero means no limit
+	wantWrite bool        // EPOLLOUT is watched, accessed by the loop only
+	lingering *time.Timer // forces closing when the outbound queue can not be drained
 }
 
-// Close ..
+// Close closes the connection after the outbound queue and preWrite are flushed
 func (c *Conn) Close(preWrite []byte) error {
+	c.wmux.Lock()
 	if c.closed {
-		return errors.New("connection already closed")
+		c.wmux.Unlock()
+		return ErrConnClosed
 	}
-	c.loop.poll.ModDetach(c.fd)
-	loopCloseConn(c.loop, c, preWrite)
 	c.closed = true
-	return nil
+	if c.tls == nil {
+		c.appendFrame(preWrite)
+	}
+	c.wmux.Unlock()
+
+	if c.tls != nil && preWrite != nil {
+		c.tls.conn.Write(preWrite)
+	}
+
+	return c.loop.trigger(&loopCloseNote{c: c})
+}
+
+// AddrIndex returns the index of the listener that accepted the connection
+func (c *Conn) AddrIndex() int {
+	return c.addrIndex
+}
+
+// RemoteAddr returns the remote address of the connection
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Closed states if the connection is closed or being closed
+func (c *Conn) Closed() bool {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	return c.closed
+}
+
+// Send queues bts to be written by the loop. The client is disconnected
+// when the outbound queue limit is exceeded
+func (c *Conn) Send(bts []byte) error {
+	if err := c.enqueue(bts); err != nil {
+		if err == ErrSlowConsumer {
+			Sugar.Warn("Disconnecting slow consumer: ", c.remoteAddr)
+			c.loop.metrics.slowConsumers.Inc()
+			c.discardOutbound()
+			submitDisconnect(c, c.client, CloseFrameSlowConsumer)
+		}
+		return err
+	}
+	return c.loop.trigger(&loopWriteNote{c: c})
 }
 
-// Write ..
+// Write queues p to be written by the loop
 func (c *Conn) Write(p []byte) (int, error) {
-	return c.netConn.Write(p)
+	if err := c.enqueue(p); err != nil {
+		return 0, err
+	}
+	if err := c.loop.trigger(&loopWriteNote{c: c}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// enqueue appends p to the outbound queue, encrypted by the TLS layer if any
+func (c *Conn) enqueue(p []byte) error {
+	c.wmux.Lock()
+	if c.closed {
+		c.wmux.Unlock()
+		return ErrConnClosed
+	}
+	if c.maxOut > 0 && len(c.outbuf)+len(p) > c.maxOut {
+		c.wmux.Unlock()
+		return ErrSlowConsumer
+	}
+	if c.tls == nil {
+		c.appendFrame(p)
+		c.wmux.Unlock()
+		return nil
+	}
+	c.wmux.Unlock()
+	_, err := c.tls.conn.Write(p)
+	return err
+}
+
+// appendOut appends the bytes encoded by the TLS layer to the outbound queue
+func (c *Conn) appendOut(p []byte) {
+	c.wmux.Lock()
+	c.outbuf = append(c.outbuf, p...)
+	c.wmux.Unlock()
 }
 
-// Read ..
+// appendFrame appends the frame p to the outbound queue, it must be called with c.wmux held
+func (c *Conn) appendFrame(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.outbuf = append(c.outbuf, p...)
+	c.frames = append(c.frames, len(p))
+}
+
+// discardOutbound drops the queued frames that are not started to be written, the rest of
+// a partially written frame is kept so that a close frame can follow it. The queue of
+// a TLS connection holds records which can not be dropped, it is kept as is
+func (c *Conn) discardOutbound() {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	if c.tls != nil {
+		return
+	}
+	if c.headSent > 0 {
+		c.outbuf = c.outbuf[:c.frames[0]-c.headSent]
+		c.frames = c.frames[:1]
+		return
+	}
+	c.outbuf = c.outbuf[:0]
+	c.frames = c.frames[:0]
+}
+
+// flush writes the outbound queue to the socket until it would block.
+// drained is true when nothing is left in the queue
+func (c *Conn) flush() (drained bool, err error) {
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	for len(c.outbuf) > 0 {
+		n, err := syscall.Write(c.fd, c.outbuf)
+		if n > 0 {
+			c.outbuf = c.outbuf[:copy(c.outbuf, c.outbuf[n:])]
+			c.sent(n)
+		}
+		if err == syscall.EAGAIN {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sent removes the frames completely written by n bytes from frames
+func (c *Conn) sent(n int) {
+	c.headSent += n
+	for len(c.frames) > 0 && c.headSent >= c.frames[0] {
+		c.headSent -= c.frames[0]
+		c.frames = c.frames[:copy(c.frames, c.frames[1:])]
+	}
+}
+
+// Read reads from the socket without blocking, it returns syscall.EAGAIN when nothing is left to read
 func (c *Conn) Read(p []byte) (int, error) {
+	if c.tls != nil {
+		n, err := c.tls.conn.Read(p)
+		if err == errWouldBlock {
+			err = syscall.EAGAIN
+		}
+		return n, err
+	}
 	return readRawConn(c.rawConn, p)
 }
 
@@ -59,16 +232,35 @@ func readRawConn(c syscall.RawConn, b []byte) (int, error) {
 	return n, nil
 }
 
-func socketFD(conn net.Conn) int {
-	//tls := reflect.TypeOf(conn.UnderlyingConn()) == reflect.TypeOf(&tls.Conn{})
-	// Extract the file descriptor associated with the connection
-	//connVal := reflect.Indirect(reflect.ValueOf(conn)).FieldByName("conn").Elem()
-	tcpConn := reflect.Indirect(reflect.ValueOf(conn)).FieldByName("conn")
-	//if tls {
-	//	tcpConn = reflect.Indirect(tcpConn.Elem())
-	//}
-	fdVal := tcpConn.FieldByName("fd")
-	pfdVal := reflect.Indirect(fdVal).FieldByName("pfd")
+// peekRawConn reads from c without consuming the bytes read
+func peekRawConn(c syscall.RawConn, b []byte) (int, error) {
+	var operr error
+	var n int
+	err := c.Read(func(s uintptr) bool {
+		n, _, operr = syscall.Recvfrom(int(s), b, syscall.MSG_PEEK)
+		return true
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, operr
+}
 
-	return int(pfdVal.FieldByName("Sysfd").Int())
+// socketFD returns the file descriptor of conn and its raw connection
+func socketFD(conn net.Conn) (int, syscall.RawConn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, nil, errors.New("connection does not expose its file descriptor")
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return 0, nil, err
+	}
+	fd := -1
+	if err := rawConn.Control(func(sysfd uintptr) {
+		fd = int(sysfd)
+	}); err != nil {
+		return 0, nil, err
+	}
+	return fd, rawConn, nil
 }
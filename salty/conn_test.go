@@ -0,0 +1,309 @@
+package salty
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// newLoopTestServer runs a server on the event loops listening on a unix domain socket
+func newLoopTestServer(t *testing.T, configure func(s *Server)) (*Server, string) {
+	sock := filepath.Join(t.TempDir(), "salty.sock")
+	return startLoopTestServer(t, configure, sock), sock
+}
+
+// startLoopTestServer runs a server on the event loops listening on the unix domain sockets socks
+func startLoopTestServer(t *testing.T, configure func(s *Server), socks ...string) *Server {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	if configure != nil {
+		configure(s)
+	}
+	addrs := make([]string, len(socks))
+	for i, sock := range socks {
+		addrs[i] = unixPrefix + sock
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Start(addrs...)
+	}()
+	require.Eventually(t, func() bool {
+		for _, sock := range socks {
+			if _, err := os.Stat(sock); err != nil {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		<-served
+	})
+	return s
+}
+
+// loopTestConn is a connection dialed by dialLoop, it reads the bytes buffered by the dialer first
+type loopTestConn struct {
+	*net.UnixConn
+	r io.Reader
+}
+
+func (c *loopTestConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func dialLoop(t *testing.T, sock, key string) io.ReadWriter {
+	conn, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}.Dial(context.Background(), "ws://salty/"+key)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	c := &loopTestConn{UnixConn: conn.(*net.UnixConn), r: conn}
+	if br != nil {
+		c.r = br
+	}
+	return c
+}
+
+func TestConn_DiscardOutbound(t *testing.T) {
+	c := &Conn{}
+	c.appendFrame([]byte("first"))
+	c.appendFrame([]byte("second"))
+	c.discardOutbound()
+	require.Empty(t, c.outbuf)
+	require.Empty(t, c.frames)
+
+	// the rest of the frame being written is kept
+	c.appendFrame([]byte("first"))
+	c.appendFrame([]byte("second"))
+	c.appendFrame([]byte("third"))
+	c.outbuf = c.outbuf[:copy(c.outbuf, c.outbuf[7:])]
+	c.sent(7)
+	require.Equal(t, []int{6, 5}, c.frames)
+	require.Equal(t, 2, c.headSent)
+	c.discardOutbound()
+	require.Equal(t, "cond", string(c.outbuf))
+
+	c.appendFrame(CloseFrameSlowConsumer)
+	require.Equal(t, append([]byte("cond"), CloseFrameSlowConsumer...), c.outbuf)
+	c.outbuf = c.outbuf[:0]
+	c.sent(4 + len(CloseFrameSlowConsumer))
+	require.Empty(t, c.frames)
+	require.Equal(t, 0, c.headSent)
+}
+
+func TestConn_SlowConsumer(t *testing.T) {
+	s, sock := newLoopTestServer(t, func(s *Server) {
+		s.MaxOutboundBytes = 1024
+	})
+	rw := dialLoop(t, sock, testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	clients := s.pathClients(testPathKey)
+	require.Len(t, clients, 1)
+	require.Equal(t, ErrSlowConsumer, s.WriteCtrl(clients[0].conn, make([]byte, 2048)))
+	requireClosedWith(t, rw, prot.CloseCodeSlowConsumer)
+}
+
+func TestStreamConn_SlowConsumer(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	s.MaxOutboundBytes = 1024
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	clients := s.pathClients(testPathKey)
+	require.Len(t, clients, 1)
+	require.Equal(t, ErrSlowConsumer, s.WriteCtrl(clients[0].conn, make([]byte, 2048)))
+	requireClosedWith(t, rw, prot.CloseCodeSlowConsumer)
+}
+
+func TestConn_MessageTooBig(t *testing.T) {
+	_, sock := newLoopTestServer(t, func(s *Server) {
+		s.MaxMessageSize = 1024
+	})
+	rw := dialLoop(t, sock, testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	require.Nil(t, wsutil.WriteClientBinary(rw, make([]byte, 1025)))
+	requireClosedWith(t, rw, prot.CloseCodeMessageTooBig)
+}
+
+func TestConn_RelaysBeforeEOF(t *testing.T) {
+	s, sock := newLoopTestServer(t, nil)
+	initiator := newTestPeer(t)
+	initiator.connectLoop(sock, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+	responder := newTestPeer(t)
+	responder.connectLoop(sock, initiator.pathKey())
+	responder.authResponder(s, 0)
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	// the last message arrives together with FIN
+	h := responder.header(initiator.id)
+	responder.send(h, []byte("answer"))
+	require.Nil(t, responder.rw.(*loopTestConn).CloseWrite())
+
+	got, _, _ := initiator.receive()
+	require.Equal(t, h, got)
+	_, msg, _ = initiator.receive()
+	require.Equal(t, string(prot.Disconnected), msg["type"])
+}
+
+func TestConn_CloseFrameBeforeEOF(t *testing.T) {
+	_, sock := newLoopTestServer(t, nil)
+	rw := dialLoop(t, sock, testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	closeFrame := ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))
+	require.Nil(t, ws.WriteFrame(rw, ws.MaskFrameInPlace(closeFrame)))
+	require.Nil(t, rw.(*loopTestConn).CloseWrite())
+	requireClosedWith(t, rw, int(ws.StatusNormalClosure))
+}
+
+func TestConn_PingAndCloseFrame(t *testing.T) {
+	s, sock := newLoopTestServer(t, nil)
+	initiator := newTestPeer(t)
+	initiator.connectLoop(sock, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+	responder := newTestPeer(t)
+	responder.connectLoop(sock, initiator.pathKey())
+	responder.authResponder(s, 0)
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	// a masked ping is answered with its payload
+	require.Nil(t, ws.WriteFrame(responder.rw, ws.MaskFrameInPlace(ws.NewPingFrame([]byte("ping")))))
+	frame, err := ws.ReadFrame(responder.rw)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpPong, frame.Header.OpCode)
+	require.Equal(t, []byte("ping"), frame.Payload)
+
+	// the close code is echoed and the initiator is notified
+	closeFrame := ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusGoingAway, "bye"))
+	require.Nil(t, ws.WriteFrame(responder.rw, ws.MaskFrameInPlace(closeFrame)))
+	requireClosedWith(t, responder.rw, int(ws.StatusGoingAway))
+	_, msg, _ = initiator.receive()
+	require.Equal(t, string(prot.Disconnected), msg["type"])
+	require.Equal(t, int64(responder.id), msg["id"])
+}
+
+func TestConn_StalledUpgrade(t *testing.T) {
+	_, sock := newLoopTestServer(t, func(s *Server) {
+		s.HandshakeTimeout = 500 * time.Millisecond
+	})
+	stalled, err := net.Dial("unix", sock)
+	require.Nil(t, err)
+	defer stalled.Close()
+	_, err = stalled.Write([]byte("GET /" + testPathKey + " HTTP/1.1\r\nHost: salty\r\n"))
+	require.Nil(t, err)
+
+	// the next connection on the loop is served
+	rw := dialLoop(t, sock, testPathKey)
+	_, _, err = wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	// the stalled one is closed by the deadline of the upgrade
+	stalled.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = stalled.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+}
+
+func TestConn_HandshakeDeadlineFromAccept(t *testing.T) {
+	_, sock := newLoopTestServer(t, func(s *Server) {
+		s.HandshakeTimeout = 600 * time.Millisecond
+	})
+	dialed := time.Now()
+	conn, err := net.Dial("unix", sock)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// the time spent in the upgrade counts against the handshake timeout
+	time.Sleep(400 * time.Millisecond)
+	_, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return conn, nil
+		},
+	}.Dial(context.Background(), "ws://salty/"+testPathKey)
+	require.Nil(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rw := &loopTestConn{UnixConn: conn.(*net.UnixConn), r: conn}
+	if br != nil {
+		rw.r = br
+	}
+	_, _, err = wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+	requireClosedWith(t, rw, prot.CloseCodeTimeout)
+	require.Less(t, int64(time.Since(dialed)), int64(time.Second))
+}
+
+func TestConn_MultipleLoops(t *testing.T) {
+	s, sock := newLoopTestServer(t, func(s *Server) {
+		s.NumLoops = 4
+	})
+	initiator := newTestPeer(t)
+	initiator.connectLoop(sock, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+	responder := newTestPeer(t)
+	responder.connectLoop(sock, initiator.pathKey())
+	responder.authResponder(s, 0)
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	// the connections are balanced over the loops
+	loops := map[int]bool{}
+	for _, client := range s.pathClients(initiator.pathKey()) {
+		loops[client.conn.(*Conn).loop.idx] = true
+	}
+	require.Len(t, loops, 2)
+
+	h := initiator.header(responder.id)
+	initiator.send(h, []byte("offer"))
+	got, _, _ := responder.receive()
+	require.Equal(t, h, got)
+	h = responder.header(initiator.id)
+	responder.send(h, []byte("answer"))
+	got, _, _ = initiator.receive()
+	require.Equal(t, h, got)
+
+	require.Nil(t, s.Shutdown(context.Background()))
+	for _, l := range s.loops {
+		require.Zero(t, atomic.LoadInt32(&l.count))
+		require.Empty(t, l.fdconns)
+	}
+	for _, rw := range []io.ReadWriter{initiator.rw, responder.rw} {
+		// disconnected may be received first when the other peer is closed before
+		frame, err := ws.ReadFrame(rw)
+		for err == nil && frame.Header.OpCode == ws.OpBinary {
+			frame, err = ws.ReadFrame(rw)
+		}
+		require.Nil(t, err)
+		require.Equal(t, ws.OpClose, frame.Header.OpCode)
+		code, _ := ws.ParseCloseFrameData(frame.Payload)
+		require.Equal(t, ws.StatusCode(prot.CloseCodeGoingAway), code)
+	}
+}
@@ -14,6 +14,8 @@ var (
 	ErrInvalidOverflowNumber = errors.New("Invalid overflow number")
 	// ErrNotExpectedCsn ..
 	ErrNotExpectedCsn = errors.New("unexpected sequence number")
+	// ErrInvalidCsnLength ..
+	ErrInvalidCsnLength = errors.New("the length of csnBytes must be 6 bytes")
 )
 
 // CombinedSequenceNumber ..
@@ -98,7 +100,7 @@ func NewCombinedSequenceNumber(initialSequenceNum uint32) *CombinedSequenceNumbe
 // ParseCombinedSequenceNumber ..
 func ParseCombinedSequenceNumber(csnBytes []byte) (*CombinedSequenceNumber, error) {
 	if len(csnBytes) != 6 {
-		return nil, errors.New("the length of csnBytes must be 6 bytes")
+		return nil, ErrInvalidCsnLength
 	}
 
 	csn := &CombinedSequenceNumber{
@@ -0,0 +1,70 @@
+package salty
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Drain makes the server reject the new connections with CloseFrameTryAgainLater,
+// or only the ones opening a new path if DrainNewPathsOnly is set. The clients left
+// are closed with CloseFrameTryAgainLater when MaxDrainTime passes
+func (s *Server) Drain() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return
+	}
+	Sugar.Info("Draining the server")
+	if s.MaxDrainTime > 0 {
+		s.drainTimer = time.AfterFunc(s.MaxDrainTime, s.onDrainTimeout)
+	}
+}
+
+// Undrain makes the server accept the new connections again
+func (s *Server) Undrain() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if !atomic.CompareAndSwapInt32(&s.draining, 1, 0) {
+		return
+	}
+	Sugar.Info("Stopped draining the server")
+	s.stopDrainTimer()
+}
+
+// Draining states if the server is in drain mode
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// stopDrainTimer must be called with s.mux held
+func (s *Server) stopDrainTimer() {
+	if s.drainTimer != nil {
+		s.drainTimer.Stop()
+		s.drainTimer = nil
+	}
+}
+
+func (s *Server) onDrainTimeout() {
+	if !s.Draining() {
+		return
+	}
+	Sugar.Info("Maximum drain time passed, closing the remaining clients")
+	for _, c := range s.conns() {
+		submitDisconnect(c, c.client, CloseFrameTryAgainLater)
+	}
+	for _, client := range s.streamClients() {
+		submitDisconnect(client.conn, client, CloseFrameTryAgainLater)
+	}
+}
+
+// acceptsPath states if a new connection to the path of key can be accepted
+func (s *Server) acceptsPath(key string) bool {
+	if !s.Draining() {
+		return true
+	}
+	if !s.DrainNewPathsOnly {
+		return false
+	}
+	_, ok := s.paths.Get(key)
+	return ok
+}
@@ -0,0 +1,26 @@
+package salty
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsPath(t *testing.T) {
+	require := require.New(t)
+
+	s := NewServer(nacl.BoxKeyPair{})
+	s.paths.Join("existing")
+	require.True(s.acceptsPath("existing"))
+	require.True(s.acceptsPath("new"))
+
+	atomic.StoreInt32(&s.draining, 1)
+	require.False(s.acceptsPath("existing"))
+	require.False(s.acceptsPath("new"))
+
+	s.DrainNewPathsOnly = true
+	require.True(s.acceptsPath("existing"))
+	require.False(s.acceptsPath("new"))
+}
@@ -0,0 +1,60 @@
+package salty
+
+import (
+	"errors"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+)
+
+var (
+	// ErrCookiesNotMatched occurs when your_cookie of client-auth differs from the cookie of the server
+	ErrCookiesNotMatched = errors.New("cookies do not match")
+	// ErrSameCookies occurs when the client uses the cookie of the server
+	ErrSameCookies = errors.New("server and client cookies could not be the same")
+	// ErrCookieInChanged occurs when the cookie of the client changes between messages
+	ErrCookieInChanged = errors.New("client cookieIn can not change")
+	// ErrInvalidSubprotocol occurs when the client does not support the subprotocol of the server
+	ErrInvalidSubprotocol = errors.New("invalid subprotocol")
+	// ErrNoPermanentKey occurs when the server does not have any permanent key pair
+	ErrNoPermanentKey = errors.New("server does not have a permanent key pair")
+	// ErrInvalidServerKey occurs when your_key of client-auth matches none of the permanent keys of the server
+	ErrInvalidServerKey = errors.New("your_key matches none of permanent key pairs of server")
+	// ErrInvalidClientKey occurs when the public key of a client is not valid
+	ErrInvalidClientKey = errors.New("invalid client public key")
+	// ErrRoleNotGranted occurs when the client takes a role other than the one of its Grant
+	ErrRoleNotGranted = errors.New("role is not granted")
+)
+
+// CloseCodeOf maps err to the close code that the connection should be closed with
+func CloseCodeOf(err error) int {
+	var flowErr *prot.MessageFlowError
+	var fieldErr *prot.PayloadFieldError
+	var stateErr *StateError
+
+	switch {
+	case errors.Is(err, ErrInvalidServerKey),
+		errors.Is(err, ErrInvalidClientKey):
+		return prot.CloseCodeInvalidKey
+	case errors.Is(err, prot.ErrSlotsFull):
+		return prot.CloseCodePathFullError
+	case errors.Is(err, ErrRoleNotGranted):
+		return prot.CloseCodePolicyViolation
+	case errors.As(err, &flowErr),
+		errors.As(err, &fieldErr),
+		errors.As(err, &stateErr),
+		errors.Is(err, prot.ErrHeaderLengthUnexpected),
+		errors.Is(err, prot.ErrCantDecodePayload),
+		errors.Is(err, prot.ErrCantDecryptPayload),
+		errors.Is(err, ErrCookiesNotMatched),
+		errors.Is(err, ErrSameCookies),
+		errors.Is(err, ErrCookieInChanged),
+		errors.Is(err, ErrInvalidSubprotocol),
+		errors.Is(err, ErrOverflowSentinel),
+		errors.Is(err, ErrInvalidOverflowNumber),
+		errors.Is(err, ErrNotExpectedCsn),
+		errors.Is(err, ErrInvalidCsnLength):
+		return prot.CloseCodeProtocolError
+	default:
+		return prot.CloseCodeInternalError
+	}
+}
@@ -0,0 +1,43 @@
+package salty
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{ErrInvalidServerKey, prot.CloseCodeInvalidKey},
+		{ErrInvalidClientKey, prot.CloseCodeInvalidKey},
+		{prot.ErrSlotsFull, prot.CloseCodePathFullError},
+		{fmt.Errorf("Could not allocate Id for responder : %w", prot.ErrSlotsFull), prot.CloseCodePathFullError},
+		{ErrRoleNotGranted, prot.CloseCodePolicyViolation},
+		{prot.NewMessageFlowError("flow", nil), prot.CloseCodeProtocolError},
+		{prot.NewPayloadFieldError("client-hello", "key", nil), prot.CloseCodeProtocolError},
+		{NewStateError(ServerHello, RoleUndetermined, prot.DropResponder), prot.CloseCodeProtocolError},
+		{prot.ErrHeaderLengthUnexpected, prot.CloseCodeProtocolError},
+		{prot.ErrCantDecodePayload, prot.CloseCodeProtocolError},
+		{prot.ErrCantDecryptPayload, prot.CloseCodeProtocolError},
+		{ErrCookiesNotMatched, prot.CloseCodeProtocolError},
+		{ErrSameCookies, prot.CloseCodeProtocolError},
+		{ErrCookieInChanged, prot.CloseCodeProtocolError},
+		{ErrInvalidSubprotocol, prot.CloseCodeProtocolError},
+		{ErrOverflowSentinel, prot.CloseCodeProtocolError},
+		{ErrInvalidOverflowNumber, prot.CloseCodeProtocolError},
+		{ErrNotExpectedCsn, prot.CloseCodeProtocolError},
+		{ErrInvalidCsnLength, prot.CloseCodeProtocolError},
+		{ErrNoPermanentKey, prot.CloseCodeInternalError},
+		{errors.New("unknown"), prot.CloseCodeInternalError},
+		{nil, prot.CloseCodeInternalError},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.code, CloseCodeOf(tt.err), "%v", tt.err)
+	}
+}
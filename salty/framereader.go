@@ -0,0 +1,187 @@
+package salty
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	ws "github.com/gobwas/ws"
+)
+
+const (
+	// readChunkSize is the minimum free space in the buffer of a frameReader before reading
+	readChunkSize = 4096
+	// maxHeaderSize is the size of the longest frame header
+	maxHeaderSize = 14
+)
+
+// ErrMessageTooBig occurs when a message received is longer than the maximum message size
+var ErrMessageTooBig = errors.New("message exceeds the maximum size")
+
+// Message is a complete WebSocket message, or a control frame, received from a client
+type Message struct {
+	OpCode  ws.OpCode
+	Payload []byte
+}
+
+// frameReader accumulates the bytes read from a non-blocking connection across
+// the I/O events and yields complete messages only. Control frames interleaved
+// with the fragments of a message are yielded as soon as they are complete
+type frameReader struct {
+	state   ws.State
+	buf     []byte
+	off     int
+	maxSize int64 // limit of the payload of a message, zero means no limit
+
+	fragmentOp ws.OpCode
+	fragments  []byte
+}
+
+func newFrameReader(maxSize int) *frameReader {
+	return &frameReader{
+		state:   ws.StateServerSide,
+		maxSize: int64(maxSize),
+	}
+}
+
+// Fill reads from src until src returns an error, e.g. syscall.EAGAIN, or the bytes
+// not parsed yet can hold a message of the maximum size. In the latter case it returns
+// a nil error and should be called again after the messages are taken by Next.
+// It returns io.EOF when src has no more data to read
+func (r *frameReader) Fill(src io.Reader) (int, error) {
+	total := 0
+	for {
+		if r.maxSize > 0 && int64(len(r.buf)-r.off) >= r.maxSize+maxHeaderSize {
+			return total, nil
+		}
+		r.grow(readChunkSize)
+		n, err := src.Read(r.buf[len(r.buf):cap(r.buf)])
+		if n > 0 {
+			r.buf = r.buf[:len(r.buf)+n]
+			total += n
+		}
+		if err != nil {
+			return total, err
+		}
+		if n <= 0 {
+			return total, io.EOF
+		}
+	}
+}
+
+// Feed appends p to the bytes that are not parsed yet
+func (r *frameReader) Feed(p []byte) {
+	r.grow(len(p))
+	r.buf = append(r.buf, p...)
+}
+
+// Next returns the next complete message. ok is false when more bytes are required
+func (r *frameReader) Next() (msg Message, ok bool, err error) {
+	for {
+		h, payload, ok, err := r.nextFrame()
+		if err != nil || !ok {
+			return msg, false, err
+		}
+
+		if h.OpCode.IsControl() {
+			return Message{OpCode: h.OpCode, Payload: payload}, true, nil
+		}
+
+		if h.OpCode != ws.OpContinuation {
+			r.fragmentOp = h.OpCode
+		}
+		if !h.Fin {
+			r.state = r.state.Set(ws.StateFragmented)
+			r.fragments = append(r.fragments, payload...)
+			continue
+		}
+
+		if r.state.Fragmented() {
+			payload = append(r.fragments, payload...)
+			r.state = r.state.Clear(ws.StateFragmented)
+			r.fragments = nil
+		}
+		if r.fragmentOp == ws.OpText && !utf8.Valid(payload) {
+			return msg, false, ws.ErrProtocolInvalidUTF8
+		}
+		return Message{OpCode: r.fragmentOp, Payload: payload}, true, nil
+	}
+}
+
+// nextFrame parses the next complete frame and returns its unmasked payload
+func (r *frameReader) nextFrame() (h ws.Header, payload []byte, ok bool, err error) {
+	b := r.buf[r.off:]
+	if len(b) < 2 {
+		return
+	}
+
+	h.Fin = b[0]&0x80 != 0
+	h.Rsv = (b[0] & 0x70) >> 4
+	h.OpCode = ws.OpCode(b[0] & 0x0f)
+	h.Masked = b[1]&0x80 != 0
+
+	n := 2
+	switch length := b[1] & 0x7f; length {
+	case 126:
+		if len(b) < n+2 {
+			return
+		}
+		h.Length = int64(binary.BigEndian.Uint16(b[n:]))
+		n += 2
+	case 127:
+		if len(b) < n+8 {
+			return
+		}
+		l := binary.BigEndian.Uint64(b[n:])
+		if l > 1<<63-1 {
+			err = ws.ErrHeaderLengthMSB
+			return
+		}
+		h.Length = int64(l)
+		n += 8
+	default:
+		h.Length = int64(length)
+	}
+	if h.Masked {
+		if len(b) < n+4 {
+			return
+		}
+		copy(h.Mask[:], b[n:])
+		n += 4
+	}
+
+	if err = ws.CheckHeader(h, r.state); err != nil {
+		return
+	}
+	if r.maxSize > 0 && !h.OpCode.IsControl() && int64(len(r.fragments))+h.Length > r.maxSize {
+		err = ErrMessageTooBig
+		return
+	}
+	if int64(len(b)-n) < h.Length {
+		return
+	}
+
+	end := n + int(h.Length)
+	payload = make([]byte, h.Length)
+	copy(payload, b[n:end])
+	if h.Masked {
+		ws.Cipher(payload, h.Mask, 0)
+	}
+	r.off += end
+	return h, payload, true, nil
+}
+
+// grow makes room for n more bytes, dropping the parsed ones
+func (r *frameReader) grow(n int) {
+	if r.off > 0 {
+		r.buf = r.buf[:copy(r.buf, r.buf[r.off:])]
+		r.off = 0
+	}
+	if cap(r.buf)-len(r.buf) >= n {
+		return
+	}
+	buf := make([]byte, len(r.buf), 2*cap(r.buf)+n)
+	copy(buf, r.buf)
+	r.buf = buf
+}
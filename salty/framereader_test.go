@@ -0,0 +1,232 @@
+package salty
+
+import (
+	"bytes"
+	"io"
+	"syscall"
+	"testing"
+
+	ws "github.com/gobwas/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameReader_ByteByByte(t *testing.T) {
+	require := require.New(t)
+
+	payload := bytes.Repeat([]byte{0x01, 0x02, 0x03}, 100)
+	data := compileClientFrame(ws.NewBinaryFrame(payload))
+
+	r := newFrameReader(0)
+	for i := 0; i < len(data)-1; i++ {
+		r.Feed(data[i : i+1])
+		_, ok, err := r.Next()
+		require.Nil(err)
+		require.False(ok)
+	}
+	r.Feed(data[len(data)-1:])
+
+	msg, ok, err := r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal(ws.OpBinary, msg.OpCode)
+	require.Equal(payload, msg.Payload)
+
+	_, ok, err = r.Next()
+	require.Nil(err)
+	require.False(ok)
+}
+
+func TestFrameReader_MultipleFrames(t *testing.T) {
+	require := require.New(t)
+
+	var data []byte
+	data = append(data, compileClientFrame(ws.NewBinaryFrame([]byte("first")))...)
+	data = append(data, compileClientFrame(ws.NewBinaryFrame(make([]byte, 70000)))...)
+	data = append(data, compileClientFrame(ws.NewBinaryFrame([]byte("third")))...)
+
+	r := newFrameReader(0)
+	r.Feed(data)
+
+	msg, ok, err := r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal([]byte("first"), msg.Payload)
+
+	msg, ok, err = r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Len(msg.Payload, 70000)
+
+	msg, ok, err = r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal([]byte("third"), msg.Payload)
+}
+
+func TestFrameReader_Continuation(t *testing.T) {
+	require := require.New(t)
+
+	var data []byte
+	data = append(data, compileClientFrame(ws.NewFrame(ws.OpBinary, false, []byte("abc")))...)
+	data = append(data, compileClientFrame(ws.NewPingFrame([]byte("ping")))...)
+	data = append(data, compileClientFrame(ws.NewFrame(ws.OpContinuation, false, []byte("def")))...)
+	data = append(data, compileClientFrame(ws.NewFrame(ws.OpContinuation, true, []byte("ghi")))...)
+
+	r := newFrameReader(0)
+	r.Feed(data)
+
+	msg, ok, err := r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal(ws.OpPing, msg.OpCode)
+	require.Equal([]byte("ping"), msg.Payload)
+
+	msg, ok, err = r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal(ws.OpBinary, msg.OpCode)
+	require.Equal([]byte("abcdefghi"), msg.Payload)
+}
+
+func TestFrameReader_ProtocolErrors(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		{ws.MustCompileFrame(ws.NewBinaryFrame([]byte("unmasked"))), ws.ErrProtocolMaskRequired},
+		{compileClientFrame(ws.NewFrame(ws.OpContinuation, true, []byte("x"))), ws.ErrProtocolContinuationUnexpected},
+		{compileClientFrame(ws.NewFrame(ws.OpPing, false, nil)), ws.ErrProtocolControlNotFinal},
+		{append(
+			compileClientFrame(ws.NewFrame(ws.OpBinary, false, []byte("x"))),
+			compileClientFrame(ws.NewBinaryFrame([]byte("y")))...,
+		), ws.ErrProtocolContinuationExpected},
+		{compileClientFrame(ws.NewTextFrame([]byte{0xff, 0xfe})), ws.ErrProtocolInvalidUTF8},
+	}
+	for _, tt := range tests {
+		r := newFrameReader(0)
+		r.Feed(tt.data)
+
+		var err error
+		for ok := true; ok && err == nil; {
+			_, ok, err = r.Next()
+		}
+		require.Equal(t, tt.err, err)
+	}
+}
+
+func TestFrameReader_Fill(t *testing.T) {
+	require := require.New(t)
+
+	payload := make([]byte, 3*readChunkSize)
+	data := compileClientFrame(ws.NewBinaryFrame(payload))
+
+	r := newFrameReader(0)
+	src := &eagainReader{r: bytes.NewReader(data[:len(data)/2])}
+	_, err := r.Fill(src)
+	require.Equal(syscall.EAGAIN, err)
+
+	_, ok, err := r.Next()
+	require.Nil(err)
+	require.False(ok)
+
+	src = &eagainReader{r: bytes.NewReader(data[len(data)/2:])}
+	n, err := r.Fill(src)
+	require.Equal(syscall.EAGAIN, err)
+	require.Equal(len(data)-len(data)/2, n)
+
+	msg, ok, err := r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Equal(payload, msg.Payload)
+}
+
+func TestFrameReader_FillEOF(t *testing.T) {
+	require := require.New(t)
+
+	r := newFrameReader(0)
+	_, err := r.Fill(bytes.NewReader(nil))
+
+	require.Equal(io.EOF, err)
+}
+
+func TestFrameReader_MaxSize(t *testing.T) {
+	require := require.New(t)
+
+	// rejected by the header before the payload arrives
+	data := compileClientFrame(ws.NewBinaryFrame(make([]byte, 101)))
+	r := newFrameReader(100)
+	r.Feed(data[:8])
+	_, _, err := r.Next()
+	require.Equal(ErrMessageTooBig, err)
+
+	r = newFrameReader(100)
+	r.Feed(compileClientFrame(ws.NewBinaryFrame(make([]byte, 100))))
+	msg, ok, err := r.Next()
+	require.Nil(err)
+	require.True(ok)
+	require.Len(msg.Payload, 100)
+
+	// the fragments count together
+	r = newFrameReader(100)
+	r.Feed(compileClientFrame(ws.NewFrame(ws.OpBinary, false, make([]byte, 60))))
+	r.Feed(compileClientFrame(ws.NewFrame(ws.OpContinuation, true, make([]byte, 41))))
+	_, _, err = r.Next()
+	require.Equal(ErrMessageTooBig, err)
+}
+
+func TestFrameReader_FillLimit(t *testing.T) {
+	require := require.New(t)
+
+	var data []byte
+	for i := 0; i < 100; i++ {
+		data = append(data, compileClientFrame(ws.NewBinaryFrame(make([]byte, 100)))...)
+	}
+	r := newFrameReader(100)
+	src := &eagainReader{r: bytes.NewReader(data)}
+
+	// Fill stops when a message of the maximum size fits, until the messages are taken
+	n, err := r.Fill(src)
+	require.Nil(err)
+	require.Less(n, len(data))
+	total, messages := n, 0
+	for err == nil {
+		for {
+			_, ok, err := r.Next()
+			require.Nil(err)
+			if !ok {
+				break
+			}
+			messages++
+		}
+		n, err = r.Fill(src)
+		total += n
+	}
+	require.Equal(syscall.EAGAIN, err)
+	require.Equal(len(data), total)
+	for {
+		_, ok, err := r.Next()
+		require.Nil(err)
+		if !ok {
+			break
+		}
+		messages++
+	}
+	require.Equal(100, messages)
+}
+
+// eagainReader reads from r and returns syscall.EAGAIN when r is drained, like a non-blocking socket
+type eagainReader struct {
+	r io.Reader
+}
+
+func (e *eagainReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		return -1, syscall.EAGAIN
+	}
+	return n, err
+}
+
+func compileClientFrame(f ws.Frame) []byte {
+	return ws.MustCompileFrame(ws.MaskFrame(f))
+}
@@ -0,0 +1,153 @@
+package salty
+
+import (
+	"fmt"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+)
+
+// State represents the handshake state of a client
+type State int
+
+// STATES
+const (
+	None State = iota + 1
+	ServerHello
+	ClientHello
+	ClientAuth
+	ServerAuth
+)
+
+func (s State) String() string {
+	switch s {
+	case None:
+		return "none"
+	case ServerHello:
+		return "server-hello"
+	case ClientHello:
+		return "client-hello"
+	case ClientAuth:
+		return "client-auth"
+	case ServerAuth:
+		return "server-auth"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// Role represents the role of a client on its path
+type Role int
+
+// ROLES
+const (
+	// RoleUndetermined is the role of a client until it sends client-hello or client-auth
+	RoleUndetermined Role = iota
+	RoleInitiator
+	RoleResponder
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleUndetermined:
+		return "undetermined"
+	case RoleInitiator:
+		return "initiator"
+	case RoleResponder:
+		return "responder"
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// RelayMessage represents any message sent to another client through the server
+const RelayMessage prot.MessageType = "relay"
+
+// StateError occurs when a message is not allowed in the state of a client
+type StateError struct {
+	State   State
+	Role    Role
+	Message prot.MessageType
+}
+
+// NewStateError creates StateError instance
+func NewStateError(state State, role Role, msgType prot.MessageType) *StateError {
+	return &StateError{
+		State:   state,
+		Role:    role,
+		Message: msgType,
+	}
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("message %s is not allowed for %s in state %s", e.Message, e.Role, e.State)
+}
+
+// Transition declares that Message moves a client with Role from the state From to the state To
+type Transition struct {
+	From    State
+	Role    Role
+	Message prot.MessageType
+	To      State
+}
+
+type transitionKey struct {
+	state   State
+	role    Role
+	message prot.MessageType
+}
+
+// HandshakeFSM validates the messages received from clients against a transition table.
+// The transitions to ServerHello and ServerAuth are made by the server itself
+// when it sends server-hello and server-auth
+type HandshakeFSM struct {
+	transitions map[transitionKey]State
+	plaintext   map[State][]prot.MessageType
+}
+
+// NewHandshakeFSM creates HandshakeFSM instance with the given transitions
+func NewHandshakeFSM(transitions []Transition, plaintext map[State][]prot.MessageType) *HandshakeFSM {
+	fsm := &HandshakeFSM{
+		transitions: make(map[transitionKey]State, len(transitions)),
+		plaintext:   plaintext,
+	}
+	for _, t := range transitions {
+		fsm.transitions[transitionKey{t.From, t.Role, t.Message}] = t.To
+	}
+	return fsm
+}
+
+// Next returns the state that a client with role moves to when it sends msgType in state.
+// It returns StateError if the message is not allowed
+func (fsm *HandshakeFSM) Next(state State, role Role, msgType prot.MessageType) (State, error) {
+	next, ok := fsm.transitions[transitionKey{state, role, msgType}]
+	if !ok {
+		return state, NewStateError(state, role, msgType)
+	}
+	return next, nil
+}
+
+// AllowsPlaintext states if msgType can be received unencrypted in state
+func (fsm *HandshakeFSM) AllowsPlaintext(state State, msgType prot.MessageType) bool {
+	for _, t := range fsm.plaintext[state] {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// handshakeFSM is the state machine of the SaltyRTC signalling handshake
+var handshakeFSM = NewHandshakeFSM(
+	[]Transition{
+		// responder handshake
+		{ServerHello, RoleUndetermined, prot.ClientHello, ClientHello},
+		{ClientHello, RoleResponder, prot.ClientAuth, ClientAuth},
+		// initiator handshake
+		{ServerHello, RoleUndetermined, prot.ClientAuth, ClientAuth},
+		// authenticated clients
+		{ServerAuth, RoleInitiator, prot.DropResponder, ServerAuth},
+		{ServerAuth, RoleInitiator, RelayMessage, ServerAuth},
+		{ServerAuth, RoleResponder, RelayMessage, ServerAuth},
+	},
+	map[State][]prot.MessageType{
+		ServerHello: {prot.ClientHello},
+	},
+)
@@ -0,0 +1,72 @@
+package salty
+
+import (
+	"testing"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshakeFSM_Next(t *testing.T) {
+	states := []State{None, ServerHello, ClientHello, ClientAuth, ServerAuth}
+	roles := []Role{RoleUndetermined, RoleInitiator, RoleResponder}
+	messages := []prot.MessageType{
+		prot.ServerHello,
+		prot.ClientHello,
+		prot.ClientAuth,
+		prot.ServerAuth,
+		prot.NewResponder,
+		prot.NewInitiator,
+		prot.DropResponder,
+		prot.SendError,
+		prot.Disconnected,
+		RelayMessage,
+	}
+	allowed := map[transitionKey]State{
+		{ServerHello, RoleUndetermined, prot.ClientHello}: ClientHello,
+		{ServerHello, RoleUndetermined, prot.ClientAuth}:  ClientAuth,
+		{ClientHello, RoleResponder, prot.ClientAuth}:     ClientAuth,
+		{ServerAuth, RoleInitiator, prot.DropResponder}:   ServerAuth,
+		{ServerAuth, RoleInitiator, RelayMessage}:         ServerAuth,
+		{ServerAuth, RoleResponder, RelayMessage}:         ServerAuth,
+	}
+
+	for _, state := range states {
+		for _, role := range roles {
+			for _, msgType := range messages {
+				next, err := handshakeFSM.Next(state, role, msgType)
+
+				want, ok := allowed[transitionKey{state, role, msgType}]
+				if ok {
+					require.Nil(t, err, "%s %s %s", state, role, msgType)
+					require.Equal(t, want, next, "%s %s %s", state, role, msgType)
+					continue
+				}
+				require.IsType(t, &StateError{}, err, "%s %s %s", state, role, msgType)
+				require.Equal(t, state, next)
+				require.Equal(t, prot.CloseCodeProtocolError, CloseCodeOf(err))
+			}
+		}
+	}
+}
+
+func TestHandshakeFSM_AllowsPlaintext(t *testing.T) {
+	tests := []struct {
+		state   State
+		msgType prot.MessageType
+		output  bool
+	}{
+		{None, prot.ClientHello, false},
+		{ServerHello, prot.ClientHello, true},
+		{ServerHello, prot.ClientAuth, false},
+		{ClientHello, prot.ClientHello, false},
+		{ClientHello, prot.ClientAuth, false},
+		{ClientAuth, prot.ClientAuth, false},
+		{ServerAuth, prot.DropResponder, false},
+	}
+	for _, tt := range tests {
+		if out := handshakeFSM.AllowsPlaintext(tt.state, tt.msgType); out != tt.output {
+			t.Fatalf("bad:\nInput:\n%s %s\nOutput:\n%v\nExpected output:\n%v", tt.state, tt.msgType, out, tt.output)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package salty
+
+import (
+	"context"
+	"net"
+	"os"
+)
+
+// ListenerFiles returns duplicates of the listening sockets in the order they were given
+// to the server. They can be passed to a new process taking over the listeners, e.g. by
+// LISTEN_FDS, while this one finishes serving its clients
+func (s *Server) ListenerFiles() ([]*os.File, error) {
+	s.mux.Lock()
+	lns := s.listeners
+	s.mux.Unlock()
+
+	files := make([]*os.File, 0, len(lns))
+	for _, ln := range lns {
+		var f *os.File
+		var err error
+		switch netln := ln.ln.(type) {
+		case *net.TCPListener:
+			f, err = netln.File()
+		case *net.UnixListener:
+			f, err = netln.File()
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// StopListening closes the listeners, the open connections are still served.
+// The socket files of the unix listeners are kept for the process they are handed over to
+func (s *Server) StopListening() {
+	s.mux.Lock()
+	loops := s.loops
+	s.mux.Unlock()
+	if loops == nil {
+		return
+	}
+	loops[0].trigger(&loopStopListeningNote{})
+}
+
+// WaitIdle waits until all connections are closed or ctx is done
+func (s *Server) WaitIdle(ctx context.Context) error {
+	s.mux.Lock()
+	loops := s.loops
+	s.mux.Unlock()
+	return s.waitConnsClosed(ctx, loops)
+}
+
+// loopStopListening closes the listeners of l keeping the socket files
+func loopStopListening(l *loop) error {
+	for _, ln := range l.lns {
+		if unixln, ok := ln.ln.(*net.UnixListener); ok {
+			unixln.SetUnlinkOnClose(false)
+		}
+	}
+	loopStopAccepting(l)
+	return nil
+}
+
+type loopStopListeningNote struct{}
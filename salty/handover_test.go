@@ -0,0 +1,59 @@
+package salty
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestListenerFiles_StopListening(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+
+	sock := filepath.Join(t.TempDir(), "salty.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Start("127.0.0.1:0", unixPrefix+sock)
+	}()
+	var files []*os.File
+	require.Eventually(t, func() bool {
+		files, err = s.ListenerFiles()
+		return err == nil && len(files) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	s.StopListening()
+	ln, err := net.FileListener(files[1])
+	require.Nil(t, err)
+	files[1].Close()
+	files[0].Close()
+
+	// the socket file stays for the listener handed over
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("unix", sock)
+	require.Nil(t, err)
+	conn.Close()
+	require.Nil(t, <-accepted)
+	ln.Close()
+
+	require.Nil(t, s.WaitIdle(context.Background()))
+	require.Nil(t, s.Shutdown(context.Background()))
+	require.Equal(t, ErrServerClosed, <-served)
+}
@@ -0,0 +1,76 @@
+package salty
+
+import (
+	"net"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+)
+
+// Hooks are the callbacks invoked on the lifecycle events of the server, the nil ones are skipped.
+// They are called synchronously, so they should hand long running work off. OnConnect and
+// OnPathCreated are called by the goroutine accepting the connection, which is the event loop
+// for the connections served by Start: blocking there stalls every connection of the loop.
+// The other ones are called by the workers and the timers of the clients
+type Hooks struct {
+	// OnConnect is called when a connection to the path of pathKey is upgraded
+	OnConnect func(remoteAddr net.Addr, pathKey string)
+	// OnHandshakeComplete is called when a client gets authenticated with the address id
+	OnHandshakeComplete func(role Role, id prot.AddressType, pathKey string)
+	// OnRelay is called when a message of size bytes is relayed from src to dest
+	OnRelay func(pathKey string, src, dest prot.AddressType, size int)
+	// OnDropResponder is called when the initiator drops the responder id with closeCode
+	OnDropResponder func(pathKey string, id prot.AddressType, closeCode int)
+	// OnDisconnect is called when a client is closed with closeCode,
+	// which is zero if the connection is lost. id is zero for unauthenticated clients
+	OnDisconnect func(pathKey string, id prot.AddressType, closeCode int)
+	// OnPathCreated is called when the first client connects to the path of pathKey
+	OnPathCreated func(pathKey string)
+	// OnPathPruned is called when the path of pathKey is removed after its last client left
+	OnPathPruned func(pathKey string)
+}
+
+func (h *Hooks) connect(remoteAddr net.Addr, pathKey string) {
+	if h.OnConnect != nil {
+		h.OnConnect(remoteAddr, pathKey)
+	}
+}
+
+func (h *Hooks) handshakeComplete(client *Client) {
+	if h.OnHandshakeComplete != nil {
+		h.OnHandshakeComplete(client.Role(), client.ID, client.Path.key)
+	}
+}
+
+func (h *Hooks) relay(pathKey string, src, dest prot.AddressType, size int) {
+	if h.OnRelay != nil {
+		h.OnRelay(pathKey, src, dest, size)
+	}
+}
+
+func (h *Hooks) dropResponder(pathKey string, id prot.AddressType, closeCode int) {
+	if h.OnDropResponder != nil {
+		h.OnDropResponder(pathKey, id, closeCode)
+	}
+}
+
+func (h *Hooks) disconnect(client *Client, closeFrame []byte) {
+	if h.OnDisconnect != nil {
+		var id prot.AddressType
+		if client.Authenticated {
+			id = client.ID
+		}
+		h.OnDisconnect(client.Path.key, id, closeFrameCode(closeFrame))
+	}
+}
+
+func (h *Hooks) pathCreated(pathKey string) {
+	if h.OnPathCreated != nil {
+		h.OnPathCreated(pathKey)
+	}
+}
+
+func (h *Hooks) pathPruned(pathKey string) {
+	if h.OnPathPruned != nil {
+		h.OnPathPruned(pathKey)
+	}
+}
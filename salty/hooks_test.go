@@ -0,0 +1,154 @@
+package salty
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHooks(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+
+	var mux sync.Mutex
+	var events []string
+	record := func(event string) {
+		mux.Lock()
+		events = append(events, event)
+		mux.Unlock()
+	}
+	pruned := make(chan struct{})
+	s.Hooks = Hooks{
+		OnConnect: func(remoteAddr net.Addr, pathKey string) {
+			require.NotNil(t, remoteAddr)
+			record("connect " + pathKey)
+		},
+		OnPathCreated: func(pathKey string) {
+			record("created " + pathKey)
+		},
+		OnDisconnect: func(pathKey string, id prot.AddressType, closeCode int) {
+			require.Equal(t, prot.AddressType(0), id)
+			require.Equal(t, prot.CloseCodeGoingAway, closeCode)
+			record("disconnect " + pathKey)
+		},
+		OnPathPruned: func(pathKey string) {
+			record("pruned " + pathKey)
+			close(pruned)
+		},
+	}
+
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, s.Shutdown(ctx))
+	select {
+	case <-pruned:
+	case <-ctx.Done():
+		t.Fatal("path is not pruned")
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	require.Equal(t, []string{
+		"created " + testPathKey,
+		"connect " + testPathKey,
+		"disconnect " + testPathKey,
+		"pruned " + testPathKey,
+	}, events)
+}
+
+func TestHooks_ServerHelloFailure(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	// server-hello exceeds the outbound queue limit
+	s.MaxOutboundBytes = 1
+
+	events := make(chan string, 8)
+	s.Hooks = Hooks{
+		OnConnect: func(remoteAddr net.Addr, pathKey string) {
+			events <- "connect"
+		},
+		OnPathCreated: func(pathKey string) {
+			events <- "created"
+		},
+		OnDisconnect: func(pathKey string, id prot.AddressType, closeCode int) {
+			events <- "disconnect"
+		},
+		OnPathPruned: func(pathKey string) {
+			events <- "pruned"
+		},
+	}
+
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, err := rw.Read(make([]byte, 1))
+	require.NotNil(t, err)
+	for _, want := range []string{"created", "connect", "disconnect", "pruned"} {
+		select {
+		case event := <-events:
+			require.Equal(t, want, event)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+	}
+	require.Equal(t, 0, s.paths.Len())
+}
+
+func TestHooks_Session(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+
+	events := make(chan string, 8)
+	s.Hooks = Hooks{
+		OnHandshakeComplete: func(role Role, id prot.AddressType, pathKey string) {
+			events <- fmt.Sprintf("handshake %s 0x%02x", role, id)
+		},
+		OnRelay: func(pathKey string, src, dest prot.AddressType, size int) {
+			events <- fmt.Sprintf("relay 0x%02x 0x%02x %d", src, dest, size)
+		},
+		OnDropResponder: func(pathKey string, id prot.AddressType, closeCode int) {
+			events <- fmt.Sprintf("drop 0x%02x %d", id, closeCode)
+		},
+	}
+	nextEvent := func() string {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			return ""
+		}
+	}
+
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+	initiator.authInitiator(s, 0)
+	require.Equal(t, "handshake "+RoleInitiator.String()+" 0x01", nextEvent())
+
+	responder := newTestPeer(t)
+	responder.connect(hs.URL, initiator.pathKey())
+	responder.authResponder(s, 0)
+	require.Equal(t, "handshake "+RoleResponder.String()+" 0x02", nextEvent())
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	initiator.send(initiator.header(responder.id), []byte("offer"))
+	_, _, data := responder.receive()
+	require.Equal(t, fmt.Sprintf("relay 0x01 0x02 %d", len(data)), nextEvent())
+
+	initiator.sendToServer(struct {
+		Type   prot.MessageType `codec:"type"`
+		ID     uint8            `codec:"id"`
+		Reason int              `codec:"reason"`
+	}{prot.DropResponder, responder.id, prot.CloseCodeInitiatorCouldNotDecrypt}, false)
+	require.Equal(t, fmt.Sprintf("drop 0x02 %d", prot.CloseCodeInitiatorCouldNotDecrypt), nextEvent())
+	requireClosedWith(t, responder.rw, prot.CloseCodeInitiatorCouldNotDecrypt)
+}
@@ -1,12 +1,18 @@
 package salty
 
 import (
+	"crypto/tls"
+	"fmt"
 	"os"
+	"strings"
 
 	"net"
 	"syscall"
 )
 
+// unixPrefix marks the addresses of unix domain sockets
+const unixPrefix = "unix:"
+
 type listener struct {
 	ln      net.Listener
 	lnaddr  net.Addr
@@ -14,6 +20,81 @@ type listener struct {
 	fd      int
 	network string
 	addr    string
+	index   int // index of the listener in the order given to the server
+
+	tlsConfig *tls.Config // TLS config of the accepted connections, nil for plain connections
+}
+
+// newListener listens on addr, which is a TCP address or the path of a unix domain socket
+// prefixed by "unix:". A stale socket file is removed and the new one gets socketMode
+func newListener(addr string, socketMode os.FileMode) (*listener, error) {
+	ln := &listener{}
+	ln.network, ln.addr = parseAddr(addr)
+
+	if ln.network == "unix" {
+		if err := removeStaleSocket(ln.addr); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	ln.ln, err = net.Listen(ln.network, ln.addr)
+	if err != nil {
+		return nil, err
+	}
+	if ln.network == "unix" {
+		if err := os.Chmod(ln.addr, socketMode); err != nil {
+			ln.close()
+			return nil, err
+		}
+	}
+	ln.lnaddr = ln.ln.Addr()
+	if err := ln.system(); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// parseAddr returns the network and the address of addr
+func parseAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+	return "tcp", addr
+}
+
+// removeStaleSocket removes the socket file at path unless a server is listening on it
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+func closeListeners(lns []*listener) {
+	for _, ln := range lns {
+		ln.close()
+	}
+}
+
+// remoteAddr returns the remote address of conn accepted by ln. The clients of a unix
+// socket are unnamed, so they are reported by the address of the socket
+func (ln *listener) remoteAddr(conn net.Conn) net.Addr {
+	addr := conn.RemoteAddr()
+	if addr == nil || addr.String() == "" {
+		return ln.lnaddr
+	}
+	return addr
 }
 
 func (ln *listener) system() error {
@@ -23,6 +104,8 @@ func (ln *listener) system() error {
 		ln.f, err = netln.File()
 	case *net.UnixListener:
 		ln.f, err = netln.File()
+	default:
+		err = fmt.Errorf("unsupported listener %T", ln.ln)
 	}
 	if err != nil {
 		ln.close()
@@ -33,9 +116,6 @@ func (ln *listener) system() error {
 }
 
 func (ln *listener) close() {
-	if ln.fd != 0 {
-		syscall.Close(ln.fd)
-	}
 	if ln.f != nil {
 		ln.f.Close()
 	}
@@ -0,0 +1,150 @@
+package salty
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParseAddr(t *testing.T) {
+	network, addr := parseAddr("127.0.0.1:3838")
+	require.Equal(t, "tcp", network)
+	require.Equal(t, "127.0.0.1:3838", addr)
+
+	network, addr = parseAddr("unix:/run/salty.sock")
+	require.Equal(t, "unix", network)
+	require.Equal(t, "/run/salty.sock", addr)
+}
+
+func TestNewListener_Unix(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "salty-unix")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "salty.sock")
+
+	ln, err := newListener(unixPrefix+path, 0600)
+	require.Nil(err)
+	fi, err := os.Stat(path)
+	require.Nil(err)
+	require.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+	_, err = newListener(unixPrefix+path, 0600)
+	require.NotNil(err)
+
+	ln.close()
+	_, err = os.Stat(path)
+	require.True(os.IsNotExist(err))
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "salty-unix")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", path)
+	require.Nil(err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	require.Nil(removeStaleSocket(path))
+	_, err = os.Stat(path)
+	require.True(os.IsNotExist(err))
+	require.Nil(removeStaleSocket(path))
+
+	file := filepath.Join(dir, "file")
+	require.Nil(ioutil.WriteFile(file, nil, 0600))
+	require.NotNil(removeStaleSocket(file))
+}
+
+type unsupportedListener struct {
+	net.Listener
+	closed bool
+}
+
+func (ln *unsupportedListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (ln *unsupportedListener) Close() error {
+	ln.closed = true
+	return nil
+}
+
+func TestServe_UnsupportedListener(t *testing.T) {
+	require := require.New(t)
+	s := NewServer(nacl.BoxKeyPair{})
+
+	ln := &unsupportedListener{}
+	require.NotNil(s.Serve(ln))
+	require.True(ln.closed)
+}
+
+func TestStart_NoAddress(t *testing.T) {
+	s := NewServer(nacl.BoxKeyPair{})
+	require.NotNil(t, s.Start())
+}
+
+func TestStart_MultipleAddresses(t *testing.T) {
+	dir := t.TempDir()
+	socks := []string{filepath.Join(dir, "first.sock"), filepath.Join(dir, "second.sock")}
+	s := startLoopTestServer(t, nil, socks...)
+
+	for i, sock := range socks {
+		peer := newTestPeer(t)
+		peer.connectLoop(sock, peer.pathKey())
+		clients := s.pathClients(peer.pathKey())
+		require.Len(t, clients, 1)
+		require.Equal(t, i, clients[0].conn.(*Conn).AddrIndex())
+	}
+}
+
+// flakyListener fails to accept with a temporary error the first errs times
+type flakyListener struct {
+	net.Listener
+	errs int
+}
+
+func (ln *flakyListener) Accept() (net.Conn, error) {
+	if ln.errs > 0 {
+		ln.errs--
+		return nil, &net.OpError{Op: "accept", Net: "unix", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return ln.Listener.Accept()
+}
+
+func TestServe_TemporaryAcceptError(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	sock := filepath.Join(t.TempDir(), "salty.sock")
+	ln, err := newListener(unixPrefix+sock, 0600)
+	require.Nil(t, err)
+	ln.ln = &flakyListener{Listener: ln.ln, errs: 3}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve([]*listener{ln})
+	}()
+	defer func() {
+		s.Shutdown(context.Background())
+		<-served
+	}()
+
+	// the connection is accepted after the errors instead of stopping the server
+	peer := newTestPeer(t)
+	peer.connectLoop(sock, peer.pathKey())
+	require.Len(t, s.pathClients(peer.pathKey()), 1)
+}
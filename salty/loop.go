@@ -1,11 +1,61 @@
 package salty
 
 import (
+	"sync"
+	"time"
+
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/evpoll"
 )
 
 type loop struct {
-	poll    *evpoll.Poll  // epoll or kqueue
-	fdconns map[int]*Conn // loop connections fd -> conn
-	count   int32         // connection count
+	idx     int               // index of the loop
+	poll    *evpoll.Poll      // epoll or kqueue
+	fdconns map[int]*Conn     // loop connections fd -> conn
+	count   int32             // connection count
+	lns     map[int]*listener // listening fd -> listener, the first loop accepts only
+	closing bool              // loop stopped accepting connections by Shutdown
+	metrics *serverMetrics
+
+	acceptDelay time.Duration // delay of accepting again after a temporary error, zero after a success
+	acceptTimer *time.Timer   // watches the listener again after acceptDelay
+
+	mux    sync.RWMutex   // guards closed against the triggers
+	closed bool           // poll is closed
+	tasks  sync.WaitGroup // upgrade goroutines and lingering timers triggering the loop
+}
+
+// trigger sends note to the loop. It returns ErrServerClosed once the poll is closed,
+// so that a late note never writes to a closed or reused eventfd
+func (l *loop) trigger(note interface{}) error {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	if l.closed {
+		return ErrServerClosed
+	}
+	return l.poll.Trigger(note)
+}
+
+// close waits for the tasks triggering the stopped loop and closes its poll
+func (l *loop) close() {
+	l.tasks.Wait()
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.closed = true
+	l.poll.Close()
+}
+
+// startLingering closes c by force once the lingering timeout is over
+func (l *loop) startLingering(c *Conn) {
+	l.tasks.Add(1)
+	c.lingering = time.AfterFunc(closeLingerTimeout, func() {
+		defer l.tasks.Done()
+		l.trigger(&loopCloseNote{c: c, force: true})
+	})
+}
+
+// stopLingering stops the lingering timer of c if any
+func (l *loop) stopLingering(c *Conn) {
+	if c.lingering != nil && c.lingering.Stop() {
+		l.tasks.Done()
+	}
 }
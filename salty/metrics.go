@@ -0,0 +1,130 @@
+package salty
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/metrics"
+	ws "github.com/gobwas/ws"
+)
+
+// serverMetrics are the metrics of a server updated by the loops, the server and the clients
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	connections         *metrics.GaugeVec
+	connectionsAccepted *metrics.CounterVec
+	initiators          *metrics.Gauge
+	responders          *metrics.Gauge
+	handshakes          *metrics.CounterVec
+	messages            *metrics.CounterVec
+	relayedMessages     *metrics.Counter
+	relayedBytes        *metrics.Counter
+	slowConsumers       *metrics.Counter
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		connections: r.NewGaugeVec("salty_connections",
+			"Number of the open connections by listener, which is its index or stream.", "listener"),
+		connectionsAccepted: r.NewCounterVec("salty_connections_accepted_total",
+			"Number of the accepted connections by listener, which is its index or stream.", "listener"),
+		initiators: r.NewGauge("salty_authenticated_initiators", "Number of the authenticated initiators."),
+		responders: r.NewGauge("salty_authenticated_responders", "Number of the authenticated responders."),
+		handshakes: r.NewCounterVec("salty_handshakes_total",
+			"Number of the finished handshakes by outcome, which is success, unauthorized or the close code of the connection, and by listener.",
+			"outcome", "listener"),
+		messages:        r.NewCounterVec("salty_messages_received_total", "Number of the messages received by type.", "type"),
+		relayedMessages: r.NewCounter("salty_relayed_messages_total", "Number of the messages relayed to another client."),
+		relayedBytes:    r.NewCounter("salty_relayed_bytes_total", "Number of the bytes relayed to another client."),
+		slowConsumers:   r.NewCounter("salty_slow_consumers_total", "Number of the clients disconnected as slow consumers."),
+	}
+	r.NewGaugeFunc("salty_paths", "Number of the paths.", func() float64 {
+		return float64(s.paths.Len())
+	})
+	r.NewGaugeFunc("salty_worker_queue_depth", "Number of the tasks waiting for a worker.", func() float64 {
+		return float64(s.wp.WaitingQueueSize())
+	})
+	return m
+}
+
+// streamListener is the listener label of the connections served by ServeConn and ServeHTTP
+const streamListener = "stream"
+
+// listenerLabel returns the listener label of c, the index of the listener that accepted
+// a loop connection or streamListener
+func listenerLabel(c Connection) string {
+	if c, ok := c.(*Conn); ok {
+		return strconv.Itoa(c.addrIndex)
+	}
+	return streamListener
+}
+
+// Metrics returns the metrics registry of s, it serves the Prometheus text format over HTTP
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
+
+// authenticated counts client as an authenticated initiator or responder
+func (m *serverMetrics) authenticated(client *Client) {
+	m.handshakes.With("success", listenerLabel(client.conn)).Inc()
+	if client.Role() == RoleInitiator {
+		m.initiators.Inc()
+	} else {
+		m.responders.Inc()
+	}
+}
+
+// disconnected counts the disconnection of client closed with closeFrame
+func (m *serverMetrics) disconnected(client *Client, closeFrame []byte) {
+	if !client.Authenticated {
+		m.rejected(client.conn, closeFrame)
+		return
+	}
+	if client.Role() == RoleInitiator {
+		m.initiators.Dec()
+	} else {
+		m.responders.Dec()
+	}
+}
+
+// rejected counts the connection c closed with closeFrame before its client is authenticated
+func (m *serverMetrics) rejected(c Connection, closeFrame []byte) {
+	m.handshakes.With(closeFrameOutcome(closeFrame), listenerLabel(c)).Inc()
+}
+
+// unauthorized counts a connection rejected by the Authorizer on listener
+func (m *serverMetrics) unauthorized(listener string) {
+	m.handshakes.With("unauthorized", listener).Inc()
+}
+
+// opened counts a connection opened on listener
+func (m *serverMetrics) opened(listener string) {
+	m.connectionsAccepted.With(listener).Inc()
+	m.connections.With(listener).Inc()
+}
+
+// closeFrameOutcome returns the close code of closeFrame, or "disconnected" for the
+// connections closed without a close frame
+func closeFrameOutcome(closeFrame []byte) string {
+	code := closeFrameCode(closeFrame)
+	if code == 0 {
+		return "disconnected"
+	}
+	return strconv.Itoa(code)
+}
+
+// closeFrameCode returns the close code of closeFrame, zero if it does not have one
+func closeFrameCode(closeFrame []byte) int {
+	if closeFrame == nil {
+		return 0
+	}
+	f, err := ws.ReadFrame(bytes.NewReader(closeFrame))
+	if err != nil || len(f.Payload) < 2 {
+		return 0
+	}
+	code, _ := ws.ParseCloseFrameData(f.Payload)
+	return int(code)
+}
@@ -0,0 +1,76 @@
+package salty
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseFrameOutcome(t *testing.T) {
+	require.Equal(t, "disconnected", closeFrameOutcome(nil))
+	require.Equal(t, "1001", closeFrameOutcome(CloseFrameGoingAway))
+	require.Equal(t, "4000", closeFrameOutcome(CloseFrameSlowConsumer))
+}
+
+func TestMetrics(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	var buf bytes.Buffer
+	s.Metrics().WriteTo(&buf)
+	require.Contains(t, buf.String(), `salty_connections{listener="stream"} 1`+"\n")
+	require.Contains(t, buf.String(), `salty_connections_accepted_total{listener="stream"} 1`+"\n")
+	require.Contains(t, buf.String(), "salty_paths 1\n")
+
+	require.Nil(t, s.Shutdown(context.Background()))
+	buf.Reset()
+	s.Metrics().WriteTo(&buf)
+	require.Contains(t, buf.String(), `salty_connections{listener="stream"} 0`+"\n")
+	require.Contains(t, buf.String(), "salty_paths 0\n")
+	require.Contains(t, buf.String(), `salty_handshakes_total{outcome="1001",listener="stream"} 1`+"\n")
+}
+
+func TestMetrics_Listener(t *testing.T) {
+	dir := t.TempDir()
+	socks := []string{filepath.Join(dir, "first.sock"), filepath.Join(dir, "second.sock")}
+	peer := newTestPeer(t)
+	s := startLoopTestServer(t, func(s *Server) {
+		s.Authorizer = authorizerFunc(func(a *Admission) error {
+			if a.PathKey != peer.pathKey() {
+				return ErrNotAuthorized
+			}
+			return nil
+		})
+	}, socks...)
+
+	peer.connectLoop(socks[0], peer.pathKey())
+	peer.authInitiator(s, 0)
+	_, _, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return net.Dial("unix", socks[1])
+		},
+	}.Dial(context.Background(), "ws://salty/"+testPathKey)
+	require.NotNil(t, err)
+
+	var buf bytes.Buffer
+	require.Eventually(t, func() bool {
+		buf.Reset()
+		s.Metrics().WriteTo(&buf)
+		return strings.Contains(buf.String(), `salty_connections{listener="1"} 0`+"\n")
+	}, time.Second, 10*time.Millisecond)
+	require.Contains(t, buf.String(), `salty_connections{listener="0"} 1`+"\n")
+	require.Contains(t, buf.String(), `salty_connections_accepted_total{listener="0"} 1`+"\n")
+	require.Contains(t, buf.String(), `salty_connections_accepted_total{listener="1"} 1`+"\n")
+	require.Contains(t, buf.String(), `salty_handshakes_total{outcome="success",listener="0"} 1`+"\n")
+	require.Contains(t, buf.String(), `salty_handshakes_total{outcome="unauthorized",listener="1"} 1`+"\n")
+}
@@ -1,6 +1,8 @@
 package salty
 
 import (
+	"sync"
+
 	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
 	hm "github.com/cornelk/hashmap"
 )
@@ -12,6 +14,10 @@ type Path struct {
 	slots    *hm.HashMap
 	lastSlot prot.AddressType
 	orphan   bool
+	clients  int // clients joined, guarded by the mutex of Paths
+
+	mux           sync.Mutex // serializes allocating the responder slots
+	maxResponders int        // smallest MaxResponders granted to the clients joined, zero means no limit
 }
 
 // NewPath ..
@@ -34,8 +40,25 @@ func (p *Path) GetInitiator() (*Client, bool) {
 	return p.Get(prot.Initiator)
 }
 
+// limitResponders lowers the limit of the responders of the path to max, zero means no limit
+func (p *Path) limitResponders(max int) {
+	if max <= 0 {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.maxResponders == 0 || max < p.maxResponders {
+		p.maxResponders = max
+	}
+}
+
 // AddResponder ..
 func (p *Path) AddResponder(c *Client) (prot.AddressType, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.maxResponders > 0 && p.numResponders() >= p.maxResponders {
+		return prot.Server, prot.ErrSlotsFull
+	}
 	lastSlot := p.lastSlot
 	var responderID prot.AddressType = lastSlot + 0x01 //0x02
 	for ; responderID <= prot.Responder; responderID = responderID + 0x01 {
@@ -56,6 +79,17 @@ func (p *Path) AddResponder(c *Client) (prot.AddressType, error) {
 	return prot.Server, prot.ErrSlotsFull
 }
 
+// numResponders returns the number of the occupied responder slots
+func (p *Path) numResponders() int {
+	n := 0
+	for id := int(prot.Initiator) + 1; id <= int(prot.Responder); id++ {
+		if _, ok := p.Get(prot.AddressType(id)); ok {
+			n++
+		}
+	}
+	return n
+}
+
 // Del ..
 func (p *Path) Del(id prot.AddressType) {
 	p.slots.Del(id)
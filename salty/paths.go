@@ -1,6 +1,8 @@
 package salty
 
 import (
+	"strings"
+	"sync"
 	"sync/atomic"
 
 	hm "github.com/cornelk/hashmap"
@@ -10,6 +12,7 @@ import (
 type Paths struct {
 	hmap   *hm.HashMap
 	number uint32
+	mux    sync.Mutex // serializes joining and leaving the paths
 }
 
 // NewPaths creates new Paths instance
@@ -20,21 +23,49 @@ func NewPaths() *Paths {
 	}
 }
 
-// GetOrCreate ..
-func (paths *Paths) GetOrCreate(key string) (*Path, bool) {
+// normalizeKey returns the hex key in lowercase, the keys of the paths and the bans are normalized
+func normalizeKey(key string) string {
+	return strings.ToLower(key)
+}
+
+// Join returns the path of key, created if it does not exist, and counts a client on it.
+// It states if the path existed
+func (paths *Paths) Join(key string) (*Path, bool) {
+	paths.mux.Lock()
+	defer paths.mux.Unlock()
+	p, ok := paths.Get(key)
+	if !ok {
+		num := atomic.AddUint32(&paths.number, 1)
+		p = NewPath(key, num)
+		paths.hmap.Set(key, p)
+	}
+	p.clients++
+	return p, ok
+}
+
+// Get returns the path of key if it exists
+func (paths *Paths) Get(key string) (*Path, bool) {
 	v, ok := paths.hmap.Get(key)
 	if p, _ := v.(*Path); ok && !p.orphan {
 		return p, true
 	}
-	num := atomic.AddUint32(&paths.number, 1)
-	p := NewPath(key, num)
-	paths.hmap.Set(key, p)
-	return p, false
+	return nil, false
+}
+
+// Len returns the number of the paths
+func (paths *Paths) Len() int {
+	return paths.hmap.Len()
 }
 
-// Prune ..
-func (paths *Paths) Prune(p *Path) {
-	if p.slots.Len() == 0 {
-		paths.hmap.Del(p.key)
+// Leave uncounts a client of p and removes p when no client is left, including the
+// ones still in the handshake. It states if p is removed by this call
+func (paths *Paths) Leave(p *Path) bool {
+	paths.mux.Lock()
+	defer paths.mux.Unlock()
+	p.clients--
+	if p.clients > 0 {
+		return false
 	}
+	paths.hmap.Del(p.key)
+	return true
 }
@@ -0,0 +1,61 @@
+package salty
+
+import (
+	"time"
+
+	ws "github.com/gobwas/ws"
+)
+
+// PingFrame is an empty ping frame sent to the clients in every ping interval
+var PingFrame = ws.MustCompileFrame(ws.NewPingFrame(nil))
+
+// startPinging schedules the pings in the interval negotiated by client-auth.
+// A ping interval of zero disables pinging
+func (c *Client) startPinging() {
+	if c.pingInterval == 0 || c.pingTimer != nil {
+		return
+	}
+	c.pongPending = false
+	c.pingTimer = time.AfterFunc(c.pingInterval, c.onPingTick)
+}
+
+// stopPinging cancels the scheduled pings
+func (c *Client) stopPinging() {
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+}
+
+// Ponged marks the last ping as answered
+func (c *Client) Ponged() {
+	c.pongPending = false
+}
+
+func (c *Client) onPingTick() {
+	c.Server.submit(func() {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+
+		if c.conn.Closed() {
+			return
+		}
+		if c.pongPending {
+			Sugar.Info("Closing due to a missing pong, client: ", c.ID)
+			c.disconnect(CloseFrameTimeout)
+			return
+		}
+		if err := c.sendPing(); err != nil {
+			Sugar.Warn("Could not send ping :", err)
+			c.disconnect(nil)
+			return
+		}
+		c.pongPending = true
+		c.pingTimer.Reset(c.pingInterval)
+	})
+}
+
+func (c *Client) sendPing() (err error) {
+	Sugar.Debug("sending ping")
+	err = c.Server.WritePing(c.conn)
+	return
+}
@@ -0,0 +1,28 @@
+package salty
+
+import (
+	"testing"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_PingTimeout(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	initiator := newTestPeer(t)
+	initiator.connect(hs.URL, initiator.pathKey())
+	initiator.authInitiator(s, 1)
+
+	// the frames are read as is, so only the first ping is answered
+	frame, err := ws.ReadFrame(initiator.rw)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpPing, frame.Header.OpCode)
+	require.Nil(t, wsutil.WriteClientMessage(initiator.rw, ws.OpPong, nil))
+
+	frame, err = ws.ReadFrame(initiator.rw)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpPing, frame.Header.OpCode)
+	requireClosedWith(t, initiator.rw, prot.CloseCodeTimeout)
+}
@@ -7,6 +7,12 @@ const (
 	CloseCodeGoingAway = 1001
 	// CloseCodeSubprotocolError is Protocol Error (WebSocket internal close code)
 	CloseCodeSubprotocolError = 1002
+	// CloseCodePolicyViolation is Policy Violation (WebSocket internal close code)
+	CloseCodePolicyViolation = 1008
+	// CloseCodeMessageTooBig is Message Too Big (WebSocket internal close code)
+	CloseCodeMessageTooBig = 1009
+	// CloseCodeTryAgainLater is Try Again Later (WebSocket internal close code)
+	CloseCodeTryAgainLater = 1013
 	// CloseCodePathFullError is Path Full
 	CloseCodePathFullError = 3000
 	// CloseCodeProtocolError is Protocol Error
@@ -25,4 +31,6 @@ const (
 	CloseCodeInvalidKey = 3007
 	// CloseCodeTimeout is Timeout
 	CloseCodeTimeout = 3008
+	// CloseCodeSlowConsumer is Slow Consumer, a private use code not defined by SaltyRTC
+	CloseCodeSlowConsumer = 4000
 )
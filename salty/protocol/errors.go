@@ -26,3 +26,8 @@ func NewMessageFlowError(message string, err error) *MessageFlowError {
 func (e *MessageFlowError) Error() string {
 	return e.Msg + ": " + e.Err.Error()
 }
+
+// Unwrap returns the underlying error
+func (e *MessageFlowError) Unwrap() error {
+	return e.Err
+}
@@ -36,3 +36,28 @@ type BaseMessage struct {
 	Src  AddressType
 	Dest AddressType
 }
+
+// TypeOf returns the message type of msg
+func TypeOf(msg interface{}) (MessageType, bool) {
+	switch msg.(type) {
+	case *ServerHelloMessage:
+		return ServerHello, true
+	case *ClientHelloMessage:
+		return ClientHello, true
+	case *ClientAuthMessage:
+		return ClientAuth, true
+	case *ServerAuthMessage:
+		return ServerAuth, true
+	case *NewResponderMessage:
+		return NewResponder, true
+	case *NewInitiatorMessage:
+		return NewInitiator, true
+	case *DropResponderMessage:
+		return DropResponder, true
+	case *SendErrorMessage:
+		return SendError, true
+	case *DisconnectedMessage:
+		return Disconnected, true
+	}
+	return "", false
+}
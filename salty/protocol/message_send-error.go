@@ -0,0 +1,40 @@
+package protocol
+
+// SendErrorMessage ..
+type SendErrorMessage struct {
+	BaseMessage
+	MessageID []byte
+
+	EncodingOpts BasicEncodingOpts
+}
+
+// NewSendErrorMessage ..
+func NewSendErrorMessage(src AddressType, dest AddressType, messageID []byte) *SendErrorMessage {
+	msg := &SendErrorMessage{
+		BaseMessage: BaseMessage{
+			Src:  src,
+			Dest: dest,
+		},
+		MessageID: messageID,
+	}
+	return msg
+}
+
+// MarshalPayload returns the bytes encoding of m
+func (m *SendErrorMessage) MarshalPayload() ([]byte, error) {
+	payload := struct {
+		Type MessageType `codec:"type"`
+		ID   []byte      `codec:"id"`
+	}{
+		Type: SendError,
+		ID:   m.MessageID,
+	}
+
+	encodedPayload, err := EncodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedPayload, err := EncryptPayload(m.EncodingOpts.ClientKey, m.EncodingOpts.ServerSessionSk, m.EncodingOpts.Nonce, encodedPayload)
+	return encryptedPayload, err
+}
@@ -0,0 +1,32 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendErrorMessage_MarshalPayload(t *testing.T) {
+	require := require.New(t)
+
+	client, err := nacl.GenerateBoxKeyPair()
+	require.Nil(err)
+	server, err := nacl.GenerateBoxKeyPair()
+	require.Nil(err)
+	nonce, _ := newTestHeader()
+	bts, _ := newTestHeader()
+	messageID := bts[CookieLength:HeaderSize]
+
+	msg := NewSendErrorMessage(Server, Initiator, messageID)
+	msg.EncodingOpts = BasicEncodingOpts{ClientKey: client.Pk, ServerSessionSk: server.Sk, Nonce: nonce}
+	encrypted, err := msg.MarshalPayload()
+	require.Nil(err)
+
+	encoded, err := DecryptPayload(server.Pk, client.Sk, nonce, encrypted)
+	require.Nil(err)
+	var payload payloadUnion
+	require.Nil(DecodePayload(encoded, &payload))
+	require.Equal(SendError, payload.Type)
+	require.Equal(messageID, payload.ID)
+}
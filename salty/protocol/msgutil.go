@@ -83,22 +83,32 @@ func ParseYourKey(yourKey interface{}) ([KeyBytesSize]byte, error) {
 
 // IsValidAddressID checks whether id is a valid address
 func IsValidAddressID(id interface{}) bool {
-	if id == nil {
-		return false
-	}
-	_, ok := id.(AddressType)
+	_, ok := addressID(id)
 	return ok
 }
 
 // ParseAddressID parses id to address of type
 func ParseAddressID(id interface{}) (AddressType, error) {
-	if !IsValidAddressID(id) {
+	v, ok := addressID(id)
+	if !ok {
 		return 0, errors.New("invalid address id")
 	}
-	v, _ := id.(AddressType)
 	return v, nil
 }
 
+// addressID converts id to an address, the integers decoded into an interface are 64 bits long
+func addressID(id interface{}) (AddressType, bool) {
+	switch v := id.(type) {
+	case AddressType:
+		return v, true
+	case uint64:
+		return AddressType(v), v <= 0xff
+	case int64:
+		return AddressType(v), v >= 0 && v <= 0xff
+	}
+	return 0, false
+}
+
 // IsValidResponderAddressID returns true if id is a valid responder address
 func IsValidResponderAddressID(id interface{}) bool {
 	v, err := ParseAddressID(id)
@@ -107,10 +117,10 @@ func IsValidResponderAddressID(id interface{}) bool {
 
 // ParseResponderAddressID parses id as address of type
 func ParseResponderAddressID(id interface{}) (AddressType, error) {
-	if !IsValidResponderAddressID(id) {
+	v, ok := addressID(id)
+	if !ok || !IsValidResponderAddressType(v) {
 		return 0, errors.New("invalid responder address id")
 	}
-	v, _ := id.(AddressType)
 	return v, nil
 }
 
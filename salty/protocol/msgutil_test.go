@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"testing"
+)
+
+func TestParseAddressID(t *testing.T) {
+	tests := []struct {
+		input  interface{}
+		output AddressType
+		valid  bool
+	}{
+		{AddressType(0x02), 0x02, true},
+		{uint64(0x02), 0x02, true},
+		{int64(0xff), 0xff, true},
+		{uint64(0x100), 0, false},
+		{int64(-1), 0, false},
+		{"0x02", 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		out, err := ParseAddressID(tt.input)
+		if (err == nil) != tt.valid || (tt.valid && out != tt.output) {
+			t.Fatalf("bad:\nInput:\n%#v\nOutput:\n%#v %v\nExpected output:\n%#v", tt.input, out, err, tt.output)
+		}
+	}
+}
+
+func TestParseResponderAddressID(t *testing.T) {
+	tests := []struct {
+		input  interface{}
+		output AddressType
+		valid  bool
+	}{
+		{AddressType(0x02), 0x02, true},
+		{int64(0xff), 0xff, true},
+		{uint64(0x02), 0x02, true},
+		{int64(0x01), 0, false},
+		{uint64(0x00), 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		out, err := ParseResponderAddressID(tt.input)
+		if (err == nil) != tt.valid || (tt.valid && out != tt.output) {
+			t.Fatalf("bad:\nInput:\n%#v\nOutput:\n%#v %v\nExpected output:\n%#v", tt.input, out, err, tt.output)
+		}
+	}
+}
@@ -35,6 +35,8 @@ const (
 	DestinationUpperBound = SourceUpperBound + DestinationLength
 	// CsnUpperBound is upper bound of combined sequence number
 	CsnUpperBound = NonceLength
+	// MessageIDLength is length of a message id (source + destination + csn) in bytes
+	MessageIDLength = NonceLength - CookieLength
 )
 
 const (
@@ -165,6 +167,18 @@ func ExtractNonce(b []byte) (n []byte, err error) {
 	return
 }
 
+// ExtractMessageID extracts id of a message from b. The id consists of source, destination and csn fields of the header
+func ExtractMessageID(b []byte) (id []byte, err error) {
+	if len(b) < HeaderSize {
+		err = ErrHeaderLengthUnexpected
+		return
+	}
+
+	id = make([]byte, MessageIDLength)
+	copy(id, b[CookieLength:HeaderSize])
+	return
+}
+
 // MakeNonce makes bytes of nonce from h
 func MakeNonce(h Header) (bts []byte) {
 	bts = make([]byte, HeaderSize)
@@ -280,7 +294,7 @@ func parseDropResponder(p payloadUnion, f Frame) (*DropResponderMessage, error)
 		return nil, NewPayloadFieldError(DropResponder, "id", err)
 	}
 	reason, err := ParseReasonCode(p.Reason)
-	if err != nil {
+	if err == nil {
 		return NewDropResponderMessageWithReason(f.Header.Src, f.Header.Dest, id, reason), nil
 	}
 	return NewDropResponderMessage(f.Header.Src, f.Header.Dest, id), nil
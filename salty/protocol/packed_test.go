@@ -155,6 +155,27 @@ func TestExtractNonce_InvalidLength(t *testing.T) {
 	require.Nil(got)
 }
 
+func TestExtractMessageID(t *testing.T) {
+	require := require.New(t)
+
+	bts, _ := newTestHeader()
+
+	got, err := ExtractMessageID(append(bts, []byte{0x01}...))
+
+	require.Nil(err)
+	require.Len(got, MessageIDLength)
+	require.Equal(bts[CookieLength:HeaderSize], got)
+}
+
+func TestExtractMessageID_InvalidLength(t *testing.T) {
+	require := require.New(t)
+
+	got, err := ExtractMessageID([]byte{0x01, 0x02})
+
+	require.Equal(ErrHeaderLengthUnexpected, err)
+	require.Nil(got)
+}
+
 func TestMakeNonce(t *testing.T) {
 	require := require.New(t)
 
@@ -196,3 +217,28 @@ func newTestHeader() (bts []byte, h Header) {
 	}
 	return
 }
+
+func TestUnmarshalMessage_DropResponder(t *testing.T) {
+	tests := []struct {
+		payload interface{}
+		reason  int
+	}{
+		{map[string]interface{}{"type": DropResponder, "id": 0x02, "reason": CloseCodeInitiatorCouldNotDecrypt}, CloseCodeInitiatorCouldNotDecrypt},
+		{map[string]interface{}{"type": DropResponder, "id": 0x02}, CloseCodeDropByInitiator},
+		{map[string]interface{}{"type": DropResponder, "id": 0x02, "reason": 1}, CloseCodeDropByInitiator},
+	}
+	for _, tt := range tests {
+		data, err := EncodePayload(tt.payload)
+		require.Nil(t, err)
+		msg, err := UnmarshalMessage(Frame{Header: Header{Src: Initiator, Dest: Server}, Payload: data})
+		require.Nil(t, err)
+		require.Equal(t, NewDropResponderMessageWithReason(Initiator, Server, 0x02, tt.reason), msg)
+	}
+
+	for _, id := range []interface{}{nil, 0x01, 0x100, "0x02"} {
+		data, err := EncodePayload(map[string]interface{}{"type": DropResponder, "id": id})
+		require.Nil(t, err)
+		_, err = UnmarshalMessage(Frame{Header: Header{Src: Initiator, Dest: Server}, Payload: data})
+		require.NotNil(t, err)
+	}
+}
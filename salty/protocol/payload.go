@@ -117,3 +117,8 @@ func NewPayloadFieldError(payloadType string, field string, err error) *PayloadF
 func (e *PayloadFieldError) Error() string {
 	return e.Type + "." + e.Field + ": " + e.Err.Error()
 }
+
+// Unwrap returns the underlying error
+func (e *PayloadFieldError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,141 @@
+package salty
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/proxyproto"
+)
+
+const (
+	// proxyPeekSize is the number of the bytes peeked first for a PROXY protocol header
+	proxyPeekSize = 512
+	// maxProxyHeaderSize is the length of the longest version 2 header
+	maxProxyHeaderSize = 16 + 1<<16 - 1
+)
+
+// readProxyHeader reads the PROXY protocol header from conn if its remote address addr
+// is trusted, and returns the address of the client. Other connections keep addr
+func (s *Server) readProxyHeader(conn io.Reader, addr net.Addr) (net.Addr, error) {
+	if !s.ProxyTrusted.Contains(addr) {
+		return addr, nil
+	}
+	h, err := proxyproto.ReadHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+	return proxyAddr(h, addr), nil
+}
+
+// loopReadProxyHeader reads the PROXY protocol header of the loop connection c without
+// blocking the loop. The header is peeked until it is complete, ok is false until then
+// and the loop tries again on the next read event. Only the bytes of the header are consumed
+func (s *Server) loopReadProxyHeader(c *Conn) (ok bool, err error) {
+	if !s.ProxyTrusted.Contains(c.remoteAddr) {
+		return true, nil
+	}
+	for size := proxyPeekSize; ; size *= 2 {
+		if size > maxProxyHeaderSize {
+			size = maxProxyHeaderSize
+		}
+		buf := make([]byte, size)
+		n, err := peekRawConn(c.rawConn, buf)
+		if err == syscall.EAGAIN {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			return false, io.EOF
+		}
+
+		r := bytes.NewReader(buf[:n])
+		h, err := proxyproto.ReadHeader(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if n == size && size < maxProxyHeaderSize {
+				// the header may be longer than the bytes peeked
+				continue
+			}
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, err := readRawConn(c.rawConn, buf[:n-r.Len()]); err != nil {
+			return false, err
+		}
+		c.remoteAddr = proxyAddr(h, c.remoteAddr)
+		return true, nil
+	}
+}
+
+// proxyAddr returns the address of the client passed by h, or addr if it is not known
+func proxyAddr(h *proxyproto.Header, addr net.Addr) net.Addr {
+	if h.Source == nil {
+		// health checks of the proxy and unknown addresses
+		return addr
+	}
+	return h.Source
+}
+
+// ProxyListener wraps ln of an http.Server passing the requests to ServeHTTP. The PROXY
+// protocol header of the connections from ProxyTrusted is read, and the source address
+// it passes becomes the remote address of the requests. Start, Serve and ServeConn read
+// the header themselves
+func (s *Server) ProxyListener(ln net.Listener) net.Listener {
+	return &proxyListener{Listener: ln, s: s}
+}
+
+type proxyListener struct {
+	net.Listener
+	s *Server
+}
+
+func (ln *proxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, s: ln.s}, nil
+}
+
+// proxyConn reads the PROXY protocol header when it is used first by the goroutine
+// serving it, so that a stalled header does not block accepting
+type proxyConn struct {
+	net.Conn
+	s    *Server
+	once sync.Once
+	addr net.Addr
+	err  error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.s.upgradeTimeout()))
+		c.addr, c.err = c.s.readProxyHeader(c.Conn, c.Conn.RemoteAddr())
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the source address passed by the PROXY protocol header, or the
+// remote address of the connection if there is no header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.addr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.addr
+}
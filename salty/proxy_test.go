@@ -0,0 +1,209 @@
+package salty
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/proxyproto"
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	s := &Server{}
+	s.ProxyTrusted, _ = proxyproto.ParseTrusted("10.0.0.0/8")
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+
+	r := bytes.NewBufferString("PROXY TCP4 192.0.2.1 10.0.0.2 56324 443\r\nGET")
+	addr, err := s.readProxyHeader(r, proxy)
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:56324", addr.String())
+	require.Equal(t, "GET", r.String())
+
+	// the connections from untrusted networks can not spoof their address
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 5000}
+	r = bytes.NewBufferString("PROXY TCP4 192.0.2.1 10.0.0.2 56324 443\r\n")
+	addr, err = s.readProxyHeader(r, client)
+	require.Nil(t, err)
+	require.Equal(t, client, addr)
+	require.Equal(t, 41, r.Len())
+
+	_, err = s.readProxyHeader(bytes.NewBufferString("GET / HTTP/1.1\r\n"), proxy)
+	require.Equal(t, proxyproto.ErrNoHeader, err)
+}
+
+// tcpConnPair returns the accepted and the dialed ends of a loopback TCP connection
+func tcpConnPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.Nil(t, err)
+	server, err := ln.Accept()
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func TestLoopReadProxyHeader(t *testing.T) {
+	s := &Server{}
+	s.ProxyTrusted, _ = proxyproto.ParseTrusted("127.0.0.0/8")
+
+	server, client := tcpConnPair(t)
+	fd, rawConn, err := socketFD(server)
+	require.Nil(t, err)
+	c := &Conn{fd: fd, rawConn: rawConn, remoteAddr: server.RemoteAddr()}
+
+	// the partial header does not block
+	ok, err := s.loopReadProxyHeader(c)
+	require.Nil(t, err)
+	require.False(t, ok)
+	client.Write([]byte("PROXY TCP4 192.0.2.1"))
+	require.Eventually(t, func() bool {
+		n, _ := peekRawConn(rawConn, make([]byte, 64))
+		return n == 20
+	}, time.Second, time.Millisecond)
+	ok, err = s.loopReadProxyHeader(c)
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	client.Write([]byte(" 10.0.0.2 56324 443\r\nGET"))
+	require.Eventually(t, func() bool {
+		ok, err = s.loopReadProxyHeader(c)
+		return ok || err != nil
+	}, time.Second, time.Millisecond)
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:56324", c.remoteAddr.String())
+	rest := make([]byte, 3)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(server, rest)
+	require.Nil(t, err)
+	require.Equal(t, "GET", string(rest))
+}
+
+func TestLoopReadProxyHeader_Long(t *testing.T) {
+	s := &Server{}
+	s.ProxyTrusted, _ = proxyproto.ParseTrusted("127.0.0.0/8")
+	server, client := tcpConnPair(t)
+	_, rawConn, err := socketFD(server)
+	require.Nil(t, err)
+	c := &Conn{rawConn: rawConn, remoteAddr: server.RemoteAddr()}
+
+	// a version 2 header with TLVs longer than the first peek
+	payload := make([]byte, 12+2*proxyPeekSize)
+	copy(payload, []byte{192, 0, 2, 1, 10, 0, 0, 2, 0xdc, 0x04, 0x01, 0xbb})
+	header := append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11"), byte(len(payload)>>8), byte(len(payload)))
+	header = append(header, payload...)
+	client.Write(append(header, "GET"...))
+
+	require.Eventually(t, func() bool {
+		ok, err := s.loopReadProxyHeader(c)
+		require.Nil(t, err)
+		return ok
+	}, time.Second, time.Millisecond)
+	require.Equal(t, "192.0.2.1:56324", c.remoteAddr.String())
+	rest := make([]byte, 3)
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(server, rest)
+	require.Nil(t, err)
+	require.Equal(t, "GET", string(rest))
+}
+
+func TestLoop_StalledProxyHeader(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	s.ProxyTrusted, _ = proxyproto.ParseTrusted("127.0.0.0/8")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ln)
+	}()
+	defer func() {
+		s.Shutdown(context.Background())
+		<-served
+	}()
+	addr := ln.Addr().String()
+
+	stalled, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer stalled.Close()
+	stalled.Write([]byte("PROXY TCP4 192.0.2.1"))
+
+	// the next connection on the loop is served
+	conn, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			conn, err := net.Dial("tcp", addr)
+			if err == nil {
+				_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 10.0.0.2 56324 443\r\n"))
+			}
+			return conn, err
+		},
+	}.Dial(context.Background(), "ws://"+addr+"/"+testPathKey)
+	require.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var rw io.ReadWriter = conn
+	if br != nil {
+		rw = struct {
+			io.Reader
+			io.Writer
+		}{br, conn}
+	}
+	_, _, err = wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:56324", s.PathInfos(testPathKey)[0].Clients[0].RemoteAddr)
+}
+
+func TestProxyListener(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	s.ProxyTrusted, _ = proxyproto.ParseTrusted("127.0.0.0/8")
+	hs := httptest.NewUnstartedServer(http.StripPrefix("/salty", s))
+	hs.Listener = s.ProxyListener(hs.Listener)
+	hs.Start()
+	defer hs.Close()
+
+	conn, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			conn, err := net.Dial("tcp", hs.Listener.Addr().String())
+			if err == nil {
+				_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 10.0.0.2 56324 443\r\n"))
+			}
+			return conn, err
+		},
+	}.Dial(context.Background(), "ws://salty/salty/"+testPathKey)
+	require.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var r io.Reader = conn
+	if br != nil {
+		r = br
+	}
+	_, _, err = wsutil.ReadServerData(struct {
+		io.Reader
+		io.Writer
+	}{r, conn})
+	require.Nil(t, err)
+	require.Equal(t, "192.0.2.1:56324", s.PathInfos(testPathKey)[0].Clients[0].RemoteAddr)
+}
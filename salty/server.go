@@ -1,18 +1,24 @@
 package salty
 
 import (
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/encoding/hexutil"
 
 	"github.com/OguzhanE/saltyrtc-server-go/pkg/evpoll"
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/proxyproto"
 	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
 	"github.com/gammazero/workerpool"
 	ws "github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
 )
 
 const (
@@ -20,6 +26,21 @@ const (
 	MaxJobs = 32
 	// MaxWorkers ..
 	MaxWorkers = 8
+	// DefaultHandshakeTimeout is the default duration that a client has to get authenticated in
+	DefaultHandshakeTimeout = 30 * time.Second
+	// DefaultTLSHandshakeTimeout is the deadline of the TLS handshake and the upgrade when HandshakeTimeout is zero
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	// DefaultMaxOutboundBytes is the default limit of the outbound queue of a connection
+	DefaultMaxOutboundBytes = 4 << 20
+	// DefaultMaxMessageSize is the default limit of the messages received
+	DefaultMaxMessageSize = 1 << 20
+	// DefaultUnixSocketMode is the default file mode of the unix domain sockets
+	DefaultUnixSocketMode = 0660
+	// closeLingerTimeout is the duration that a closing connection has to drain its outbound queue in
+	closeLingerTimeout = 5 * time.Second
+	// minAcceptDelay and maxAcceptDelay bound the delay of accepting again after a temporary error
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
 )
 
 // Server handles clients
@@ -29,6 +50,56 @@ type Server struct {
 	subprotocols   []string
 	subprotocol    string
 	permanentBoxes []*nacl.BoxKeyPair
+
+	mux       sync.Mutex
+	loops     []*loop
+	done      chan struct{} // closed when Start returns
+	shutdown  bool
+	streams   map[*streamConn]*Client // connections served by ServeConn and ServeHTTP
+	listeners []*listener
+	metrics   *serverMetrics
+
+	adminMux sync.Mutex
+	clients  map[*Client]struct{} // clients listed by the admin API
+	serial   uint64               // last serial of the clients
+	bans     map[string]struct{}  // banned initiator keys
+
+	draining   int32
+	drainTimer *time.Timer
+
+	wpMux     sync.RWMutex
+	wpStopped bool
+
+	// HandshakeTimeout is the duration that a client has to complete the handshake in,
+	// starting from the accept, the TLS handshake and the upgrade included. Zero disables
+	// the timeout, except for the TLS handshake and the upgrade which always have a deadline
+	HandshakeTimeout time.Duration
+	// MaxOutboundBytes is the limit of the bytes waiting to be written to a connection.
+	// Clients exceeding it are disconnected as slow consumers. Zero disables the limit
+	MaxOutboundBytes int
+	// MaxMessageSize is the limit of the payload of a message received in bytes, fragments
+	// included. Clients exceeding it are closed with CloseFrameMessageTooBig. Zero disables the limit
+	MaxMessageSize int
+	// DrainNewPathsOnly makes the drain mode reject only the connections opening a new path
+	DrainNewPathsOnly bool
+	// MaxDrainTime is the duration after which the clients left are closed in drain mode.
+	// Zero lets them stay until they disconnect
+	MaxDrainTime time.Duration
+	// NumLoops is the number of the event loops sharing the connections, each one
+	// has its own epoll instance and goroutine
+	NumLoops int
+	// TLSConfig enables TLS (wss://) on the listener when set
+	TLSConfig *tls.Config
+	// UnixSocketMode is the file mode of the unix domain sockets listened on
+	UnixSocketMode os.FileMode
+	// Authorizer decides if a connection can be upgraded, nil allows all
+	Authorizer Authorizer
+	// Hooks are invoked on the lifecycle events of the server
+	Hooks Hooks
+	// ProxyTrusted enables the PROXY protocol for the connections from its networks.
+	// They have to start with a PROXY protocol header, its source address becomes the remote
+	// address used by the Authorizer, the hooks and the admin API. See ProxyListener for ServeHTTP
+	ProxyTrusted proxyproto.Trusted
 }
 
 // NewServer creates new server instance
@@ -36,176 +107,453 @@ func NewServer(permanentBox nacl.BoxKeyPair) *Server {
 	permanentBoxes := []*nacl.BoxKeyPair{
 		&permanentBox,
 	}
-	return &Server{
+	s := &Server{
 		paths:          NewPaths(),
+		wp:             workerpool.New(MaxWorkers),
 		subprotocols:   []string{prot.SubprotocolSaltyRTCv1},
 		subprotocol:    prot.SubprotocolSaltyRTCv1,
 		permanentBoxes: permanentBoxes,
+
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		MaxOutboundBytes: DefaultMaxOutboundBytes,
+		MaxMessageSize:   DefaultMaxMessageSize,
+		NumLoops:         1,
+		Authorizer:       AllowAll{},
+		UnixSocketMode:   DefaultUnixSocketMode,
 	}
+	s.metrics = newServerMetrics(s)
+	return s
 }
 
-// Start runs the server
-func (s *Server) Start(addr string) error {
+// Start listens on addrs and runs the server. An address is either a TCP address,
+// or the path of a unix domain socket prefixed by "unix:"
+func (s *Server) Start(addrs ...string) error {
+	lns := make([]*listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := newListener(addr, s.UnixSocketMode)
+		if err != nil {
+			closeListeners(lns)
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	return s.serve(lns)
+}
 
-	s.wp = workerpool.New(MaxWorkers)
-	var err error
+// Serve runs the server on lns, which are closed when the server stops.
+// Only *net.TCPListener and *net.UnixListener are supported
+func (s *Server) Serve(lns ...net.Listener) error {
+	listeners := make([]*listener, 0, len(lns))
+	for i, netln := range lns {
+		ln := &listener{
+			ln:      netln,
+			lnaddr:  netln.Addr(),
+			network: netln.Addr().Network(),
+			addr:    netln.Addr().String(),
+		}
+		if err := ln.system(); err != nil {
+			closeListeners(listeners)
+			for _, netln := range lns[i+1:] {
+				netln.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, ln)
+	}
+	return s.serve(listeners)
+}
 
-	ln := &listener{
-		network: "tcp",
-		addr:    addr,
+func (s *Server) serve(lns []*listener) error {
+	if len(lns) == 0 {
+		return errors.New("no address to listen on")
+	}
+	lnfds := make(map[int]*listener, len(lns))
+	for i, ln := range lns {
+		ln.index = i
+		ln.tlsConfig = s.TLSConfig
+		lnfds[ln.fd] = ln
+		Sugar.Info("Connection listening on ", ln.network, ":", ln.lnaddr.String())
 	}
 
-	ln.ln, err = net.Listen(ln.network, ln.addr)
-	if err != nil {
-		Sugar.Fatal(err)
+	numLoops := s.NumLoops
+	if numLoops < 1 {
+		numLoops = 1
+	}
+	loops := make([]*loop, numLoops)
+	for i := range loops {
+		loops[i] = &loop{
+			idx:     i,
+			poll:    evpoll.OpenPoll(),
+			fdconns: make(map[int]*Conn),
+			metrics: s.metrics,
+		}
+	}
+	// the first loop accepts the connections and balances them over all loops
+	loops[0].lns = lnfds
+	for _, ln := range lns {
+		loops[0].poll.AddReadOnce(ln.fd)
 	}
-	ln.lnaddr = ln.ln.Addr()
-	Sugar.Info("Connection listening on ", ln.lnaddr.String())
 
-	ln.system()
+	s.mux.Lock()
+	if s.shutdown {
+		s.mux.Unlock()
+		for _, l := range loops {
+			loopStopped(l)
+			l.close()
+		}
+		return ErrServerClosed
+	}
+	s.loops = loops
+	s.listeners = lns
+	s.done = make(chan struct{})
+	defer s.closeLoops(loops)
+	s.mux.Unlock()
+
+	errs := make(chan error, len(loops))
+	for _, l := range loops[1:] {
+		go func(l *loop) {
+			errs <- s.runLoop(l)
+		}(l)
+	}
+	err := s.runLoop(loops[0])
+	// the other loops are stopped by the first one
+	for _, l := range loops[1:] {
+		l.trigger(err)
+	}
+	for range loops[1:] {
+		<-errs
+	}
+	return err
+}
 
-	poll := evpoll.OpenPoll()
-	loop := &loop{
-		poll:    poll,
-		fdconns: make(map[int]*Conn),
+// closeLoops closes the polls of the stopped loops once their tasks are done, then done
+func (s *Server) closeLoops(loops []*loop) {
+	for _, l := range loops {
+		l.close()
 	}
-	poll.AddReadOnce(ln.fd)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	close(s.done)
+}
 
-	Sugar.Debug("Waiting for an I/O event on an connection file descriptor")
-	return poll.Wait(func(fd int, note interface{}) error {
+// runLoop waits for the I/O events of l until it is stopped
+func (s *Server) runLoop(l *loop) error {
+	Sugar.Debug("Waiting for an I/O event on an connection file descriptor, loop: ", l.idx)
+	err := l.poll.Wait(func(fd int, note interface{}) error {
 		Sugar.Debug("Triggered for an event on fd: ", fd)
-		if fd == ln.fd {
-			defer poll.ModReadOnce(fd)
+		if ln, ok := l.lns[fd]; ok {
+			return s.loopAccept(l, ln)
 		}
 		if fd == 0 {
-			return loopNote(loop, note)
+			return loopNote(l, note)
 		}
-		c := loop.fdconns[fd]
+		c := l.fdconns[fd]
 		switch {
 		case c == nil:
-			return loopAccept(fd, loop, ln)
+			return nil
 		case !c.opened:
-			return loopOpened(loop, ln, c)
+			return loopOpened(l, c)
 		default:
-			return s.loopRead(loop, ln, c)
+			return s.loopRead(l, c)
 		}
 	})
+	loopStopped(l)
+	return err
 }
 
-func (s *Server) loopRead(l *loop, ln *listener, c *Conn) error {
+func (s *Server) loopRead(l *loop, c *Conn) error {
+	if c.Closed() {
+		return loopClose(l, c, false)
+	}
 	if !c.upgraded {
-		err := s.handleNewConn(l, ln, c)
-		if c.upgraded {
-			submitServerHello(l, c.client) // should we fire off by poll.Trigger?
+		if c.upgrading {
+			return nil
 		}
-		return err
+		if c.tls != nil {
+			s.startTLSHandshake(c)
+			return nil
+		}
+		return s.handleNewConn(l, c)
 	}
 
-	s.handleReceive(l, ln, c)
+	if c.wantWrite {
+		loopFlush(l, c)
+	}
+	s.handleReceive(l, c)
 	return nil
 }
 
-func (s *Server) handleReceive(l *loop, ln *listener, c *Conn) {
+func (s *Server) handleReceive(l *loop, c *Conn) {
 	Sugar.Debug("Enqueuing a task to worker pool to handle receiving data")
-	s.wp.Submit(func() {
+	s.submit(func() {
 		Sugar.Debug("The task invoked by a worker to handle receiving data")
 
 		c.client.mux.Lock()
 		defer c.client.mux.Unlock()
 
-		Sugar.Debug("Reading ws client data..")
-		data, op, err := wsutil.ReadClientData(c)
-
-		Sugar.Debug("Client data is read. OpCode: ", op)
-
-		if err != nil {
+		if c.Closed() {
+			return
+		}
 
-			if _, ok := err.(ws.ProtocolError); ok || err == syscall.EAGAIN {
-				Sugar.Debug(err)
-				// io.Copy(ioutil.Discard, c.netConn) // discard incoming data to be ready for the next
+		for !c.Closed() {
+			Sugar.Debug("Reading ws client data..")
+			_, err := c.reader.Fill(c)
+			// the frames read before an error, e.g. a close frame followed by FIN, are handled first
+			s.receive(c.client, c.reader)
+			if err == syscall.EAGAIN {
 				return
 			}
+			if err != nil {
+				if c.Closed() {
+					return
+				}
+				if err == io.EOF {
+					Sugar.Info("Connection closed by the client")
+				} else {
+					Sugar.Error("Error occurred while reading client data :", err)
+				}
+				c.client.disconnect(nil)
+				return
+			}
+		}
+	})
+}
 
-			Sugar.Error("Error occurred while reading client data :", err)
-			Sugar.Info("connection closing..")
-
-			c.Close(nil)
-			c.client.Disconnected()
-			c.client.DelFromPath()
-			s.paths.Prune(c.client.Path)
+// receive handles the complete messages of r until more bytes are required.
+// It must be called with the mutex of client held
+func (s *Server) receive(client *Client, r *frameReader) {
+	for !client.conn.Closed() {
+		msg, ok, err := r.Next()
+		if err == ErrMessageTooBig {
+			Sugar.Warn("Closing due to too big message :", client.conn.RemoteAddr())
+			client.disconnect(CloseFrameMessageTooBig)
+			return
+		}
+		if err != nil {
+			Sugar.Warn("Invalid ws frame received :", err)
+			client.disconnect(CloseFrameSubprotocolError)
+			return
+		}
+		if !ok {
 			return
 		}
 
-		if op.IsData() {
-			c.client.Received(data)
+		Sugar.Debug("Client data is read. OpCode: ", msg.OpCode)
+		if msg.OpCode.IsControl() {
+			s.handleControlFrame(client, msg)
+			continue
 		}
-	})
+		client.Received(msg.Payload)
+	}
 }
 
-func (s *Server) handleNewConn(l *loop, ln *listener, c *Conn) (resultErr error) {
-	l.poll.ModReadWrite(c.fd)
-	defer l.poll.ModRead(c.fd)
-
-	initiatorKey := ""
-	upgrader := ws.Upgrader{
-		OnRequest: func(uri []byte) error {
-			initiatorKey = string(uri)[1:]
-			return hexutil.IsValidHexPathString(initiatorKey)
-		},
+// handleControlFrame answers pings with pongs and completes the close handshake
+// started by the client. The closing client is removed from its path and its peers are notified
+func (s *Server) handleControlFrame(client *Client, msg Message) {
+	switch msg.OpCode {
+	case ws.OpPing:
+		pong, err := ws.CompileFrame(ws.NewPongFrame(msg.Payload))
+		if err == nil {
+			err = client.conn.Send(pong)
+		}
+		if err != nil {
+			Sugar.Warn("Could not send pong :", err)
+			client.disconnect(nil)
+		}
+	case ws.OpPong:
+		client.Ponged()
+	case ws.OpClose:
+		reply, err := closeReply(msg.Payload)
+		if err != nil {
+			Sugar.Warn("Invalid close frame received :", err)
+		} else {
+			code, reason := ws.ParseCloseFrameData(msg.Payload)
+			Sugar.Infof("Connection closed by the client, code: %d reason: %s", code, reason)
+		}
+		client.disconnect(reply)
 	}
+}
 
-	// Zero-copy upgrade to WebSocket connection.
-	_, err := upgrader.Upgrade(c.netConn)
-
-	if err != nil {
-		if err == syscall.EAGAIN {
+func (s *Server) handleNewConn(l *loop, c *Conn) error {
+	if !c.proxied {
+		ok, err := s.loopReadProxyHeader(c)
+		if err != nil {
+			Sugar.Warn("Could not read the PROXY protocol header :", err)
+			return loopCloseConn(l, c, nil)
+		}
+		if !ok {
 			return nil
 		}
-		Sugar.Error("Could not upgrade connection to websocket :", err)
-		return loopCloseConn(l, c, nil)
+		c.proxied = true
 	}
+	s.startUpgrade(c)
+	return nil
+}
 
+// startUpgrade upgrades c to the WebSocket protocol by a goroutine, so that a stalled
+// request does not block the loop. The loop opens the client when it is notified by loopUpgradeNote
+func (s *Server) startUpgrade(c *Conn) {
+	c.upgrading = true
+
+	c.loop.tasks.Add(1)
+	go func() {
+		defer c.loop.tasks.Done()
+		c.netConn.SetDeadline(c.accepted.Add(s.upgradeTimeout()))
+		a, err := s.upgrade(c.netConn, c.remoteAddr, listenerLabel(c))
+		c.netConn.SetDeadline(time.Time{})
+		if err != nil {
+			Sugar.Error("Could not upgrade connection to websocket :", err)
+			c.Close(nil)
+			return
+		}
+		a.accepted = c.accepted
+		c.loop.trigger(&loopUpgradeNote{s: s, c: c, a: a})
+	}()
+}
+
+// openClient creates the client of the upgraded connection c and sends server-hello
+func (s *Server) openClient(l *loop, c *Conn, a *Admission) {
+	c.maxOut = s.MaxOutboundBytes
+	client := s.acceptClient(c, a)
+	if client == nil {
+		return
+	}
+	c.client = client
+	c.reader = newFrameReader(s.MaxMessageSize)
+	c.upgraded = true
+	Sugar.Info("Connection established with the key :", a.PathKey, ", listener: ", c.addrIndex, ", remote: ", c.remoteAddr)
+	submitServerHello(client)
+}
+
+// acceptClient creates the client of the upgraded connection c admitted by a and starts its
+// handshake timer. c is closed with the matching close frame when the client can not be accepted
+func (s *Server) acceptClient(c Connection, a *Admission) *Client {
+	initiatorKey := a.PathKey
 	initiatorKeyBytes, err := hexutil.HexStringToBytes32(initiatorKey)
 	if err != nil {
 		Sugar.Warn("Closing due to invalid path key :", initiatorKey)
-		loopCloseConn(l, c, CloseFrameInvalidKey)
-		return err
+		s.metrics.rejected(c, CloseFrameInvalidKey)
+		c.Close(CloseFrameInvalidKey)
+		return nil
+	}
+
+	if s.banned(initiatorKey) {
+		Sugar.Info("Rejecting the connection to a banned path, key: ", initiatorKey)
+		s.metrics.rejected(c, CloseFramePolicyViolation)
+		c.Close(CloseFramePolicyViolation)
+		return nil
+	}
+
+	if !s.acceptsPath(initiatorKey) {
+		Sugar.Info("Rejecting the connection while draining, key: ", initiatorKey)
+		s.metrics.rejected(c, CloseFrameTryAgainLater)
+		c.Close(CloseFrameTryAgainLater)
+		return nil
 	}
 
 	var client *Client
 	box, err := nacl.GenerateBoxKeyPair()
-	path, _ := s.paths.GetOrCreate(initiatorKey)
+	path, existed := s.paths.Join(initiatorKey)
+	if !existed {
+		s.Hooks.pathCreated(initiatorKey)
+	}
 	defaultPermanentBox := s.permanentBoxes[0]
 
 	if client, _ = NewClient(c, *initiatorKeyBytes, defaultPermanentBox, box); client != nil {
+		client.grant = a.Grant
 		client.Path = path
 		client.Server = s
+		path.limitResponders(a.Grant.MaxResponders)
 	}
 
 	if err != nil || client == nil {
 		Sugar.Error("Closing due to internal err :", err)
+		s.metrics.rejected(c, CloseFrameInternalError)
 		c.Close(CloseFrameInternalError)
-		s.paths.Prune(path)
-		return err
+		s.leavePath(path)
+		return nil
+	}
+	s.addClient(client)
+	if s.HandshakeTimeout > 0 {
+		client.startHandshakeTimer(a.accepted.Add(s.HandshakeTimeout))
 	}
+	s.Hooks.connect(c.RemoteAddr(), initiatorKey)
+	return client
+}
 
-	// initialize the client
-	c.client = client
-	c.upgraded = true
-	Sugar.Info("Connection established with the key :", initiatorKey)
-	return nil
+// leavePath uncounts a client of path and removes it when no client is left
+func (s *Server) leavePath(path *Path) {
+	if s.paths.Leave(path) {
+		s.Hooks.pathPruned(path.key)
+	}
 }
 
-// Write ..
+// Write queues data as a binary frame, cb is invoked by the loop after trying to flush it
 func (s *Server) Write(c *Conn, data []byte, ctx interface{}, cb func(ctx interface{}, err error)) {
-	c.loop.poll.Trigger(&loopWriteNote{c: c, data: data, ctx: ctx, cb: cb})
+	bts, err := ws.CompileFrame(ws.NewBinaryFrame(data))
+	if err == nil {
+		err = c.enqueue(bts)
+	}
+	if err == ErrSlowConsumer {
+		Sugar.Warn("Disconnecting slow consumer: ", c.remoteAddr)
+		s.metrics.slowConsumers.Inc()
+		c.discardOutbound()
+		submitDisconnect(c, c.client, CloseFrameSlowConsumer)
+	}
+	if err != nil {
+		cb(ctx, err)
+		return
+	}
+	if err := c.loop.trigger(&loopWriteNote{c: c, ctx: ctx, cb: cb}); err != nil {
+		cb(ctx, err)
+	}
+}
+
+// WriteCtrl queues data as a binary frame
+func (s *Server) WriteCtrl(c Connection, data []byte) error {
+	bts, err := ws.CompileFrame(ws.NewBinaryFrame(data))
+	if err != nil {
+		return err
+	}
+	return c.Send(bts)
 }
 
-// WriteCtrl ..
-func (s *Server) WriteCtrl(c *Conn, data []byte) error {
-	c.loop.poll.ModReadWrite(c.fd)
-	defer c.loop.poll.ModRead(c.fd)
-	return wsutil.WriteServerBinary(c.netConn, data)
+// WritePing queues a ping frame
+func (s *Server) WritePing(c Connection) error {
+	return c.Send(PingFrame)
+}
+
+// conns returns the connections of all loops
+func (s *Server) conns() []*Conn {
+	s.mux.Lock()
+	loops, done := s.loops, s.done
+	s.mux.Unlock()
+
+	var conns []*Conn
+	for _, l := range loops {
+		ch := make(chan []*Conn, 1)
+		if l.trigger(&loopConnsNote{conns: ch}) != nil {
+			return nil
+		}
+		select {
+		case v := <-ch:
+			conns = append(conns, v...)
+		case <-done:
+			return nil
+		}
+	}
+	return conns
+}
+
+// pickLoop returns the loop with the least connections
+func (s *Server) pickLoop() *loop {
+	picked := s.loops[0]
+	for _, l := range s.loops[1:] {
+		if atomic.LoadInt32(&l.count) < atomic.LoadInt32(&picked.count) {
+			picked = l
+		}
+	}
+	return picked
 }
 
 func loopNote(l *loop, note interface{}) error {
@@ -219,42 +567,114 @@ func loopNote(l *loop, note interface{}) error {
 			return nil // ignore stale wakes
 		}
 		return handleLoopWrite(l, v)
+	case *loopCloseNote:
+		if l.fdconns[v.c.fd] != v.c {
+			return nil
+		}
+		return loopClose(l, v.c, v.force)
+	case *loopShutdownNote:
+		return loopShutdown(l, v)
+	case *loopStopListeningNote:
+		return loopStopListening(l)
+	case *loopConnsNote:
+		v.conns <- loopConns(l)
+	case *loopAttachNote:
+		return loopAttach(l, v.c)
+	case *loopUpgradeNote:
+		if l.fdconns[v.c.fd] != v.c {
+			return nil
+		}
+		return loopUpgraded(l, v)
+	case *loopAcceptNote:
+		if !l.closing {
+			l.poll.ModReadOnce(v.ln.fd)
+		}
 	}
 	return err
 }
 
-func loopAccept(fd int, l *loop, ln *listener) error {
-	if fd == ln.fd {
-		conn, err := ln.ln.Accept()
-		nfd := socketFD(conn)
-		if err != nil {
-			if err == syscall.EAGAIN {
-				return nil
-			}
-			conn.Close()
-			return err
-		}
-
-		if err := syscall.SetNonblock(nfd, true); err != nil {
-			conn.Close()
-			return err
+// loopAccept accepts a connection on ln and hands it to the loop with the least connections.
+// Temporary errors, e.g. running out of file descriptors, are logged and accepting is
+// retried with a backoff as net/http does. The other errors stop the server
+func (s *Server) loopAccept(l *loop, ln *listener) error {
+	if l.closing {
+		return nil
+	}
+	conn, err := ln.ln.Accept()
+	if err != nil {
+		if err == syscall.EAGAIN {
+			l.poll.ModReadOnce(ln.fd)
+			return nil
 		}
-		tcpConn := conn.(*net.TCPConn)
-		rawConn, err := tcpConn.SyscallConn()
-		if err != nil {
-			return err
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			loopAcceptLater(l, ln, err)
+			return nil
 		}
-		c := &Conn{netConn: conn, rawConn: rawConn, fd: nfd, loop: l}
-		l.fdconns[c.fd] = c
-		l.poll.AddReadWrite(c.fd)
-		atomic.AddInt32(&l.count, 1)
+		return err
 	}
+	l.acceptDelay = 0
+	l.poll.ModReadOnce(ln.fd)
+
+	nfd, rawConn, err := socketFD(conn)
+	if err == nil {
+		err = syscall.SetNonblock(nfd, true)
+	}
+	if err != nil {
+		Sugar.Error("Could not accept the connection :", err)
+		conn.Close()
+		return nil
+	}
+	target := s.pickLoop()
+	c := &Conn{
+		netConn:    conn,
+		rawConn:    rawConn,
+		fd:         nfd,
+		loop:       target,
+		addrIndex:  ln.index,
+		remoteAddr: ln.remoteAddr(conn),
+		accepted:   time.Now(),
+	}
+	if ln.tlsConfig != nil {
+		c.tls = newTLSState(c, ln.tlsConfig)
+	}
+	atomic.AddInt32(&target.count, 1)
+	l.metrics.opened(listenerLabel(c))
+	if target != l {
+		return target.trigger(&loopAttachNote{c: c})
+	}
+	return loopAttach(l, c)
+}
+
+// loopAcceptLater watches ln again after a delay doubled on each temporary error in a row
+func loopAcceptLater(l *loop, ln *listener, err error) {
+	l.acceptDelay *= 2
+	if l.acceptDelay == 0 {
+		l.acceptDelay = minAcceptDelay
+	}
+	if l.acceptDelay > maxAcceptDelay {
+		l.acceptDelay = maxAcceptDelay
+	}
+	Sugar.Errorf("Could not accept the connection, retrying in %v : %v", l.acceptDelay, err)
+	l.acceptTimer = time.AfterFunc(l.acceptDelay, func() {
+		l.trigger(&loopAcceptNote{ln: ln})
+	})
+}
+
+// loopAttach starts watching the events of c accepted by another loop, or by l itself
+func loopAttach(l *loop, c *Conn) error {
+	if l.closing {
+		atomic.AddInt32(&l.count, -1)
+		l.metrics.connections.With(listenerLabel(c)).Dec()
+		c.netConn.Close()
+		return nil
+	}
+	l.fdconns[c.fd] = c
+	l.poll.AddReadWrite(c.fd)
 	return nil
 }
 
-func loopOpened(l *loop, ln *listener, c *Conn) error {
+func loopOpened(l *loop, c *Conn) error {
 	c.opened = true
-	c.remoteAddr = c.netConn.RemoteAddr()
 	l.poll.ModRead(c.fd)
 	return nil
 }
@@ -264,14 +684,62 @@ func loopCloseConn(l *loop, c *Conn, preWrite []byte) error {
 		c.netConn.Write(preWrite)
 	}
 	atomic.AddInt32(&l.count, -1)
+	l.metrics.connections.With(listenerLabel(c)).Dec()
 	delete(l.fdconns, c.fd)
-	syscall.Close(c.fd)
+	l.poll.ModDetach(c.fd)
+	c.netConn.Close()
+	return nil
+}
+
+// loopFlush writes the outbound queue of c. EPOLLOUT is watched until the queue is drained
+func loopFlush(l *loop, c *Conn) error {
+	drained, err := c.flush()
+	if err != nil {
+		Sugar.Warn("Could not write to connection :", err)
+		c.discardOutbound()
+		submitDisconnect(c, c.client, nil)
+		return err
+	}
+	if drained == c.wantWrite {
+		c.wantWrite = !drained
+		if c.wantWrite {
+			l.poll.ModReadWrite(c.fd)
+		} else {
+			l.poll.ModRead(c.fd)
+		}
+	}
 	return nil
 }
 
-func submitServerHello(l *loop, client *Client) {
+// loopClose closes c once its outbound queue is drained, or when force is set
+func loopClose(l *loop, c *Conn, force bool) error {
+	if !force && loopFlush(l, c) == nil && c.wantWrite {
+		if c.lingering == nil {
+			l.startLingering(c)
+		}
+		return nil
+	}
+	l.stopLingering(c)
+	return loopCloseConn(l, c, nil)
+}
+
+// submitDisconnect disconnects client by a worker. The connection
+// is closed directly when it does not have a client yet
+func submitDisconnect(c Connection, client *Client, closeFrame []byte) {
+	if client == nil {
+		c.Close(nil)
+		return
+	}
+	client.Server.submit(func() {
+		client.mux.Lock()
+		defer client.mux.Unlock()
+		client.disconnect(closeFrame)
+	})
+}
+
+func submitServerHello(client *Client) {
 	server := client.Server
-	server.wp.Submit(func() {
+	server.submit(func() {
 		Sugar.Debug("About to submit server hello message")
 		client.mux.Lock()
 		defer client.mux.Unlock()
@@ -280,24 +748,32 @@ func submitServerHello(l *loop, client *Client) {
 }
 
 func handleLoopWrite(l *loop, note *loopWriteNote) error {
-	l.poll.ModReadWrite(note.c.fd)
-	defer l.poll.ModRead(note.c.fd)
-	err := wsutil.WriteServerBinary(note.c.netConn, note.data)
-	if err != nil {
-		Sugar.Error(err)
-		if err == syscall.EAGAIN {
-			note.cb(note.ctx, err)
-			return nil
-		}
-		loopCloseConn(l, note.c, nil)
+	err := loopFlush(l, note.c)
+	if note.cb != nil {
+		note.cb(note.ctx, err)
 	}
-	note.cb(note.ctx, err) // should we invoke callback by poll.Trigger??
 	return nil
 }
 
 type loopWriteNote struct {
-	c    *Conn
-	data []byte
-	ctx  interface{}
-	cb   func(ctx interface{}, err error)
+	c   *Conn
+	ctx interface{}
+	cb  func(ctx interface{}, err error)
+}
+
+type loopAttachNote struct {
+	c *Conn
+}
+
+type loopAcceptNote struct {
+	ln *listener
+}
+
+type loopConnsNote struct {
+	conns chan []*Conn
+}
+
+type loopCloseNote struct {
+	c     *Conn
+	force bool
 }
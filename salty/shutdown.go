@@ -0,0 +1,167 @@
+package salty
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by Start after Shutdown is called
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is the interval of checking if all connections are closed
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown stops accepting connections and closes every client with CloseFrameGoingAway,
+// including the ones served by ServeConn and ServeHTTP. It waits until the workers and
+// the outbound queues are drained or ctx is done, then it stops the loops.
+// Start returns ErrServerClosed afterwards
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mux.Lock()
+	loops, done := s.loops, s.done
+	if s.shutdown {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	s.shutdown = true
+	s.stopDrainTimer()
+	s.mux.Unlock()
+
+	Sugar.Info("Shutting down the server")
+	err := s.shutdownLoops(ctx, loops, done)
+	for _, client := range s.streamClients() {
+		submitDisconnect(client.conn, client, CloseFrameGoingAway)
+	}
+
+	if err == nil {
+		err = s.waitConnsClosed(ctx, loops)
+	}
+	if werr := s.stopWorkers(ctx); err == nil {
+		err = werr
+	}
+	if loops != nil {
+		// the first loop stops the others
+		loops[0].trigger(ErrServerClosed)
+		<-done
+	}
+	return err
+}
+
+// shutdownLoops stops the loops accepting and disconnects their clients. It returns
+// when the loops are stopped already, e.g. by an error, or ctx is done
+func (s *Server) shutdownLoops(ctx context.Context, loops []*loop, done chan struct{}) error {
+	for _, l := range loops {
+		conns := make(chan []*Conn, 1)
+		if l.trigger(&loopShutdownNote{conns: conns}) != nil {
+			return nil
+		}
+		select {
+		case v := <-conns:
+			for _, c := range v {
+				submitDisconnect(c, c.client, CloseFrameGoingAway)
+			}
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// waitConnsClosed waits until the connections of loops and the stream connections are closed
+func (s *Server) waitConnsClosed(ctx context.Context, loops []*loop) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	closed := func() bool {
+		for _, l := range loops {
+			if atomic.LoadInt32(&l.count) > 0 {
+				return false
+			}
+		}
+		return s.numStreams() == 0
+	}
+	for !closed() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// stopWorkers stops the worker pool after the queued tasks are executed.
+// The tasks submitted afterwards are dropped
+func (s *Server) stopWorkers(ctx context.Context) error {
+	s.wpMux.Lock()
+	s.wpStopped = true
+	s.wpMux.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wp.StopWait()
+		close(stopped)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stopped:
+		return nil
+	}
+}
+
+// submit enqueues task to the worker pool unless it is stopped
+func (s *Server) submit(task func()) {
+	s.wpMux.RLock()
+	defer s.wpMux.RUnlock()
+	if s.wpStopped {
+		return
+	}
+	s.wp.Submit(task)
+}
+
+// loopShutdown stops accepting and sends the open connections to note.conns
+func loopShutdown(l *loop, note *loopShutdownNote) error {
+	loopStopAccepting(l)
+	note.conns <- loopConns(l)
+	return nil
+}
+
+// loopStopAccepting closes the listeners of l
+func loopStopAccepting(l *loop) {
+	if l.closing {
+		return
+	}
+	l.closing = true
+	if l.acceptTimer != nil {
+		l.acceptTimer.Stop()
+	}
+	for _, ln := range l.lns {
+		ln.close()
+	}
+}
+
+// loopConns returns the connections of l
+func loopConns(l *loop) []*Conn {
+	conns := make([]*Conn, 0, len(l.fdconns))
+	for _, c := range l.fdconns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// loopStopped closes the connections left open after the loop is stopped.
+// The poll is closed by the server once all loops are stopped
+func loopStopped(l *loop) {
+	loopStopAccepting(l)
+	for _, c := range l.fdconns {
+		l.stopLingering(c)
+		loopCloseConn(l, c, nil)
+	}
+}
+
+type loopShutdownNote struct {
+	conns chan []*Conn
+}
@@ -0,0 +1,99 @@
+package salty
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// brokenListener fails to accept, which stops the loops
+type brokenListener struct {
+	net.Listener
+}
+
+func (ln *brokenListener) Accept() (net.Conn, error) {
+	return nil, errors.New("broken listener")
+}
+
+func TestShutdown_StoppedLoops(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	ln, err := newListener(unixPrefix+filepath.Join(t.TempDir(), "salty.sock"), 0600)
+	require.Nil(t, err)
+	ln.ln = &brokenListener{Listener: ln.ln}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.serve([]*listener{ln})
+	}()
+
+	conn, err := net.Dial("unix", ln.addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	select {
+	case err := <-served:
+		require.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server is not stopped")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Nil(t, s.Shutdown(ctx))
+}
+
+func TestShutdown_StopsWorkersOnDeadline(t *testing.T) {
+	s, sock := newLoopTestServer(t, nil)
+	peer := newTestPeer(t)
+	peer.connectLoop(sock, peer.pathKey())
+
+	// the client can not be disconnected while its mutex is held
+	client := s.pathClients(peer.pathKey())[0]
+	client.mux.Lock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, s.Shutdown(ctx))
+	client.mux.Unlock()
+
+	s.wpMux.RLock()
+	defer s.wpMux.RUnlock()
+	require.True(t, s.wpStopped)
+}
+
+func TestShutdown_TriggersAfterStop(t *testing.T) {
+	s, sock := newLoopTestServer(t, nil)
+	peer := newTestPeer(t)
+	peer.connectLoop(sock, peer.pathKey())
+	conn := s.pathClients(peer.pathKey())[0].conn.(*Conn)
+
+	// the goroutine of a stalled upgrade is waited for
+	stalled, err := net.Dial("unix", sock)
+	require.Nil(t, err)
+	defer stalled.Close()
+	_, err = stalled.Write([]byte("GET /" + testPathKey + " HTTP/1.1\r\nHost: salty\r\n"))
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		return s.metrics.connections.With(listenerLabel(conn)).Value() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, s.Shutdown(ctx))
+	stalled.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = stalled.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+
+	// the polls are closed, the notes are not written to their eventfds anymore
+	require.Equal(t, ErrServerClosed, conn.loop.trigger(&loopWriteNote{c: conn}))
+}
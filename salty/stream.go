@@ -0,0 +1,283 @@
+package salty
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/encoding/hexutil"
+	ws "github.com/gobwas/ws"
+)
+
+// streamConn is a connection served by its own goroutines, reading with blocking calls
+// and writing its outbound queue by writeLoop
+type streamConn struct {
+	netConn    net.Conn
+	remoteAddr net.Addr
+	client     *Client
+	maxOut     int // limit of the outbound queue in bytes, zero means no limit
+
+	mux    sync.Mutex
+	cond   *sync.Cond // signals writeLoop about the queued frames and closing
+	outbuf []byte
+	closed bool
+	broken bool          // writing failed, the queued frames are dropped
+	done   chan struct{} // closed when netConn is closed by writeLoop
+}
+
+func newStreamConn(netConn net.Conn, remoteAddr net.Addr, maxOut int) *streamConn {
+	c := &streamConn{
+		netConn:    netConn,
+		remoteAddr: remoteAddr,
+		maxOut:     maxOut,
+		done:       make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mux)
+	return c
+}
+
+// Send queues bts to be written by writeLoop. The client is disconnected
+// when the outbound queue limit is exceeded
+func (c *streamConn) Send(bts []byte) error {
+	c.mux.Lock()
+	if c.closed || c.broken {
+		c.mux.Unlock()
+		return ErrConnClosed
+	}
+	if c.maxOut > 0 && len(c.outbuf)+len(bts) > c.maxOut {
+		c.outbuf = nil
+		client := c.client
+		c.mux.Unlock()
+		Sugar.Warn("Disconnecting slow consumer: ", c.RemoteAddr())
+		if client != nil {
+			client.Server.metrics.slowConsumers.Inc()
+		}
+		submitDisconnect(c, client, CloseFrameSlowConsumer)
+		return ErrSlowConsumer
+	}
+	c.outbuf = append(c.outbuf, bts...)
+	c.mux.Unlock()
+	c.cond.Signal()
+	return nil
+}
+
+// Close closes the connection after the outbound queue and preWrite are written
+func (c *streamConn) Close(preWrite []byte) error {
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		return ErrConnClosed
+	}
+	c.closed = true
+	if !c.broken {
+		c.outbuf = append(c.outbuf, preWrite...)
+	}
+	c.mux.Unlock()
+	c.cond.Signal()
+	return nil
+}
+
+// Closed states if the connection is closed or being closed
+func (c *streamConn) Closed() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.closed
+}
+
+// RemoteAddr returns the remote address of the connection
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// writeLoop writes the outbound queue until the connection is closed and drained,
+// or writing fails. It closes netConn afterwards, which stops the reading too
+func (c *streamConn) writeLoop() {
+	defer close(c.done)
+	defer c.netConn.Close()
+
+	for {
+		c.mux.Lock()
+		for len(c.outbuf) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		buf, closed := c.outbuf, c.closed
+		c.outbuf = nil
+		c.mux.Unlock()
+
+		if len(buf) == 0 {
+			return
+		}
+		if closed {
+			c.netConn.SetWriteDeadline(time.Now().Add(closeLingerTimeout))
+		}
+		if _, err := c.netConn.Write(buf); err != nil {
+			Sugar.Warn("Could not write to the connection :", err)
+			c.mux.Lock()
+			c.broken = true
+			c.outbuf = nil
+			c.mux.Unlock()
+			return
+		}
+	}
+}
+
+// ServeConn runs the protocol on conn, which has not been upgraded to the WebSocket
+// protocol yet. It blocks until the connection is closed and closes conn
+func (s *Server) ServeConn(conn net.Conn) error {
+	accepted := time.Now()
+	conn.SetDeadline(accepted.Add(s.upgradeTimeout()))
+	remoteAddr, err := s.readProxyHeader(conn, conn.RemoteAddr())
+	var a *Admission
+	if err == nil {
+		a, err = s.upgrade(conn, remoteAddr, streamListener)
+	}
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	a.accepted = accepted
+	return s.serveStream(conn, nil, a)
+}
+
+// ServeHTTP upgrades the request to the WebSocket protocol and runs the protocol on
+// the hijacked connection. The path of the request is the initiator key, use
+// http.StripPrefix to mount the server under a path prefix. The remote address is the one
+// of the request, serve the listener wrapped by ProxyListener behind a PROXY protocol proxy
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	initiatorKey := normalizeKey(strings.TrimPrefix(r.URL.Path, "/"))
+	if err := hexutil.IsValidHexPathString(initiatorKey); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.isShutdown() {
+		http.Error(w, ErrServerClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	a := &Admission{
+		PathKey:    initiatorKey,
+		RemoteAddr: requestAddr(r),
+		Header:     r.Header,
+		Query:      r.URL.Query(),
+		listener:   streamListener,
+		accepted:   time.Now(),
+	}
+	if err := s.authorize(a); err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	conn, rw, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		Sugar.Error("Could not upgrade connection to websocket :", err)
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	var buffered []byte
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ = rw.Reader.Peek(n)
+	}
+	s.serveStream(conn, buffered, a)
+}
+
+// serveStream runs the protocol on the upgraded connection netConn admitted by a,
+// buffered holds the bytes read from netConn during the upgrade
+func (s *Server) serveStream(netConn net.Conn, buffered []byte, a *Admission) error {
+	if s.isShutdown() {
+		netConn.Close()
+		return ErrServerClosed
+	}
+
+	s.metrics.opened(streamListener)
+	defer s.metrics.connections.With(streamListener).Dec()
+
+	c := newStreamConn(netConn, a.RemoteAddr, s.MaxOutboundBytes)
+	go c.writeLoop()
+	client := s.acceptClient(c, a)
+	if client == nil {
+		<-c.done
+		return nil
+	}
+	c.mux.Lock()
+	c.client = client
+	c.mux.Unlock()
+
+	if !s.addStream(c, client) {
+		client.mux.Lock()
+		client.disconnect(CloseFrameGoingAway)
+		client.mux.Unlock()
+		<-c.done
+		return ErrServerClosed
+	}
+	defer s.removeStream(c)
+
+	Sugar.Info("Connection established with the key :", a.PathKey, ", remote: ", c.RemoteAddr())
+	submitServerHello(client)
+
+	reader := newFrameReader(s.MaxMessageSize)
+	reader.Feed(buffered)
+	buf := make([]byte, readChunkSize)
+	for n, err := 0, error(nil); ; n, err = netConn.Read(buf) {
+		client.mux.Lock()
+		reader.Feed(buf[:n])
+		s.receive(client, reader)
+		if err != nil && !c.Closed() {
+			Sugar.Debug("Could not read from the connection :", err)
+			client.disconnect(nil)
+		}
+		closed := c.Closed()
+		client.mux.Unlock()
+		if closed {
+			break
+		}
+	}
+	<-c.done
+	return nil
+}
+
+// addStream registers the stream connection c of client unless the server is shut down
+func (s *Server) addStream(c *streamConn, client *Client) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.shutdown {
+		return false
+	}
+	if s.streams == nil {
+		s.streams = make(map[*streamConn]*Client)
+	}
+	s.streams[c] = client
+	return true
+}
+
+func (s *Server) removeStream(c *streamConn) {
+	s.mux.Lock()
+	delete(s.streams, c)
+	s.mux.Unlock()
+}
+
+// streamClients returns the clients of the stream connections
+func (s *Server) streamClients() []*Client {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	clients := make([]*Client, 0, len(s.streams))
+	for _, client := range s.streams {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (s *Server) numStreams() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.streams)
+}
+
+func (s *Server) isShutdown() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.shutdown
+}
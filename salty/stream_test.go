@@ -0,0 +1,134 @@
+package salty
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OguzhanE/saltyrtc-server-go/pkg/crypto/nacl"
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var testPathKey = strings.Repeat("a1", 32)
+
+func newStreamTestServer(t *testing.T) (*Server, *httptest.Server) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	hs := httptest.NewServer(http.StripPrefix("/salty", s))
+	t.Cleanup(hs.Close)
+	return s, hs
+}
+
+// streamTestConn is a connection dialed by dialStream, it reads the bytes buffered by the dialer first
+type streamTestConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *streamTestConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func dialStream(t *testing.T, url string) io.ReadWriter {
+	conn, br, _, err := ws.Dial(context.Background(), url)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if br == nil {
+		return conn
+	}
+	return &streamTestConn{Conn: conn, r: br}
+}
+
+func TestServeHTTP_ServerHelloAndShutdown(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+
+	hello, op, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpBinary, op)
+	require.NotEmpty(t, hello)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, s.Shutdown(ctx))
+
+	_, _, err = wsutil.ReadServerData(rw)
+	closed, ok := err.(wsutil.ClosedError)
+	require.True(t, ok, "unexpected error: %v", err)
+	require.Equal(t, ws.StatusCode(prot.CloseCodeGoingAway), closed.Code)
+	require.Equal(t, ErrServerClosed, s.Shutdown(ctx))
+}
+
+func TestServeHTTP_Rejects(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+
+	resp, err := http.Get(hs.URL + "/salty/nothex")
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	require.Nil(t, s.Shutdown(context.Background()))
+	resp, err = http.Get(hs.URL + "/salty/" + testPathKey)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestServeConn_Drain(t *testing.T) {
+	if Sugar == nil {
+		Sugar = zap.NewNop().Sugar()
+	}
+	box, err := nacl.GenerateBoxKeyPair()
+	require.Nil(t, err)
+	s := NewServer(*box)
+	s.Drain()
+
+	server, client := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeConn(server)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	_, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			return client, nil
+		},
+	}.Dial(context.Background(), "ws://salty/"+testPathKey)
+	require.Nil(t, err)
+	var r io.Reader = client
+	if br != nil {
+		r = br
+	}
+
+	frame, err := ws.ReadFrame(r)
+	require.Nil(t, err)
+	require.Equal(t, ws.OpClose, frame.Header.OpCode)
+	code, _ := ws.ParseCloseFrameData(frame.Payload)
+	require.Equal(t, ws.StatusCode(prot.CloseCodeTryAgainLater), code)
+	require.Nil(t, <-served)
+}
+
+func TestServeHTTP_MessageTooBig(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	s.MaxMessageSize = 1024
+	rw := dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey)
+	_, _, err := wsutil.ReadServerData(rw)
+	require.Nil(t, err)
+
+	require.Nil(t, wsutil.WriteClientBinary(rw, make([]byte, 1025)))
+	requireClosedWith(t, rw, prot.CloseCodeMessageTooBig)
+}
@@ -0,0 +1,191 @@
+package salty
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errWouldBlock is returned by tlsTransport when the socket has nothing to read.
+// It is a temporary net.Error, so tls.Conn keeps its state and the read can be retried
+var errWouldBlock net.Error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "operation would block" }
+func (wouldBlockError) Timeout() bool   { return false }
+func (wouldBlockError) Temporary() bool { return true }
+
+// tlsState is the TLS layer of a connection. The handshake and the ws upgrade are done
+// by a goroutine reading and writing through the blocking net.Conn. Afterwards the
+// records are read from the socket by the workers without blocking and written to
+// the outbound queue of the connection, which is flushed by the loop
+type tlsState struct {
+	conn      *tls.Conn
+	transport *tlsTransport
+}
+
+func newTLSState(c *Conn, config *tls.Config) *tlsState {
+	transport := &tlsTransport{Conn: c.netConn, c: c}
+	return &tlsState{
+		conn:      tls.Server(transport, config),
+		transport: transport,
+	}
+}
+
+// tlsTransport is the net.Conn under tls.Conn
+type tlsTransport struct {
+	net.Conn
+	c           *Conn
+	nonblocking int32
+}
+
+func (t *tlsTransport) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&t.nonblocking) == 0 {
+		return t.Conn.Read(p)
+	}
+	n, err := readRawConn(t.c.rawConn, p)
+	if err == syscall.EAGAIN {
+		return 0, errWouldBlock
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (t *tlsTransport) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&t.nonblocking) == 0 {
+		return t.Conn.Write(p)
+	}
+	t.c.appendOut(p)
+	if err := t.c.loop.trigger(&loopWriteNote{c: t.c}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startTLSHandshake runs the TLS handshake and the ws upgrade of c by a goroutine.
+// The loop opens the client when it is notified by loopUpgradeNote
+func (s *Server) startTLSHandshake(c *Conn) {
+	c.upgrading = true
+
+	c.loop.tasks.Add(1)
+	go func() {
+		defer c.loop.tasks.Done()
+		c.netConn.SetDeadline(c.accepted.Add(s.upgradeTimeout()))
+		addr, err := s.readProxyHeader(c.netConn, c.remoteAddr)
+		if err == nil {
+			c.remoteAddr = addr
+			err = c.tls.conn.Handshake()
+		}
+		var a *Admission
+		if err == nil {
+			a, err = s.upgrade(c.tls.conn, c.remoteAddr, listenerLabel(c))
+		}
+		c.netConn.SetDeadline(time.Time{})
+		if err != nil {
+			Sugar.Warn("Could not complete the TLS handshake and the upgrade :", err)
+			c.Close(nil)
+			return
+		}
+		a.accepted = c.accepted
+		atomic.StoreInt32(&c.tls.transport.nonblocking, 1)
+		c.loop.trigger(&loopUpgradeNote{s: s, c: c, a: a})
+	}()
+}
+
+// upgradeTimeout returns the deadline of the TLS handshakes and the upgrades, so that the
+// goroutines of the stalled connections never leak
+func (s *Server) upgradeTimeout() time.Duration {
+	if s.HandshakeTimeout > 0 {
+		return s.HandshakeTimeout
+	}
+	return DefaultTLSHandshakeTimeout
+}
+
+// loopUpgraded opens the client of the upgraded connection c, it reads the bytes that
+// could arrive while the loop was not watching c
+func loopUpgraded(l *loop, note *loopUpgradeNote) error {
+	c := note.c
+	if c.Closed() {
+		return loopClose(l, c, false)
+	}
+	note.s.openClient(l, c, note.a)
+	if c.upgraded {
+		note.s.handleReceive(l, c)
+	}
+	return nil
+}
+
+type loopUpgradeNote struct {
+	s *Server
+	c *Conn
+	a *Admission
+}
+
+// CertReloader serves the certificate loaded from its files, it is replaced on Reload
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Value // *tls.Certificate
+}
+
+// NewCertReloader creates CertReloader instance loading certFile and keyFile
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again, the current certificate is kept on error
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+// NewTLSConfig creates the TLS config serving the certificate of r.
+// If clientCAFile is given, the clients have to present a certificate signed by one of its CAs
+func NewTLSConfig(r *CertReloader, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + clientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
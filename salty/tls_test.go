@@ -0,0 +1,185 @@
+package salty
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	ws "github.com/gobwas/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloader(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "salty-tls")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "first")
+	r, err := NewCertReloader(certFile, keyFile)
+	require.Nil(err)
+	require.Equal("first", leafName(t, r))
+
+	writeTestCert(t, dir, "second")
+	require.Nil(r.Reload())
+	require.Equal("second", leafName(t, r))
+
+	require.Nil(ioutil.WriteFile(certFile, []byte("broken"), 0600))
+	require.NotNil(r.Reload())
+	require.Equal("second", leafName(t, r))
+}
+
+func TestNewCertReloader_MissingFiles(t *testing.T) {
+	_, err := NewCertReloader("missing.pem", "missing.key")
+	require.NotNil(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "salty-tls")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeTestCert(t, dir, "server")
+	r, err := NewCertReloader(certFile, keyFile)
+	require.Nil(err)
+
+	config, err := NewTLSConfig(r, "")
+	require.Nil(err)
+	require.Equal(tls.NoClientCert, config.ClientAuth)
+
+	config, err = NewTLSConfig(r, certFile)
+	require.Nil(err)
+	require.Equal(tls.RequireAndVerifyClientCert, config.ClientAuth)
+	require.NotNil(config.ClientCAs)
+
+	_, err = NewTLSConfig(r, keyFile)
+	require.NotNil(err)
+}
+
+func leafName(t *testing.T, r *CertReloader) string {
+	cert, err := r.GetCertificate(nil)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	return leaf.Subject.CommonName
+}
+
+func writeTestCert(t *testing.T, dir string, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	require.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return
+}
+
+func TestServer_UpgradeTimeout(t *testing.T) {
+	s := &Server{HandshakeTimeout: time.Second}
+	require.Equal(t, time.Second, s.upgradeTimeout())
+
+	s.HandshakeTimeout = 0
+	require.Equal(t, DefaultTLSHandshakeTimeout, s.upgradeTimeout())
+}
+
+// dialTLS dials the path of key on the loop test server at sock over TLS
+func dialTLS(t *testing.T, sock, key string, config *tls.Config) io.ReadWriter {
+	conn, br, _, err := ws.Dialer{
+		NetDial: func(context.Context, string, string) (net.Conn, error) {
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.Handshake(); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}.Dial(context.Background(), "ws://salty/"+key)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if br == nil {
+		return conn
+	}
+	return struct {
+		io.Reader
+		io.Writer
+	}{br, conn}
+}
+
+func TestServer_TLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "salty")
+	r, err := NewCertReloader(certFile, keyFile)
+	require.Nil(t, err)
+	serverConfig, err := NewTLSConfig(r, "")
+	require.Nil(t, err)
+	s, sock := newLoopTestServer(t, func(s *Server) {
+		s.TLSConfig = serverConfig
+	})
+
+	caPEM, err := ioutil.ReadFile(certFile)
+	require.Nil(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(caPEM))
+	clientConfig := &tls.Config{RootCAs: roots, ServerName: "salty"}
+
+	initiator := newTestPeer(t)
+	initiator.rw = dialTLS(t, sock, initiator.pathKey(), clientConfig)
+	initiator.serverHello()
+	initiator.authInitiator(s, 0)
+	responder := newTestPeer(t)
+	responder.rw = dialTLS(t, sock, initiator.pathKey(), clientConfig)
+	responder.serverHello()
+	responder.authResponder(s, 0)
+	_, msg, _ := initiator.receive()
+	require.Equal(t, string(prot.NewResponder), msg["type"])
+
+	// the records of a large message do not fit in the socket buffer at once,
+	// the rest is flushed on EPOLLOUT
+	h := initiator.header(responder.id)
+	payload := make([]byte, 256<<10)
+	rand.Read(payload)
+	initiator.send(h, payload)
+	got, _, data := responder.receive()
+	require.Equal(t, h, got)
+	require.Equal(t, payload, data[prot.NonceLength:])
+
+	closeFrame := ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))
+	require.Nil(t, ws.WriteFrame(responder.rw, ws.MaskFrameInPlace(closeFrame)))
+	requireClosedWith(t, responder.rw, int(ws.StatusNormalClosure))
+	_, msg, _ = initiator.receive()
+	require.Equal(t, string(prot.Disconnected), msg["type"])
+}
@@ -0,0 +1,109 @@
+package salty
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken occurs when an access token is malformed, or its signature or path key does not match
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrTokenExpired occurs when an access token is used after its expiry
+	ErrTokenExpired = errors.New("access token expired")
+)
+
+// TokenClaims are the claims of an access token granting a client to connect to the path of PathKey
+type TokenClaims struct {
+	PathKey       string `json:"key"`
+	Expiry        int64  `json:"exp"`                      // unix time in seconds
+	MaxResponders int    `json:"max_responders,omitempty"` // see Grant.MaxResponders
+	Role          string `json:"role,omitempty"`           // initiator or responder, empty allows both
+}
+
+// grant returns the Grant of the claims
+func (tc *TokenClaims) grant() (Grant, error) {
+	g := Grant{MaxResponders: tc.MaxResponders}
+	switch tc.Role {
+	case "":
+	case RoleInitiator.String():
+		g.Role = RoleInitiator
+	case RoleResponder.String():
+		g.Role = RoleResponder
+	default:
+		return g, ErrInvalidToken
+	}
+	if g.MaxResponders < 0 {
+		return g, ErrInvalidToken
+	}
+	return g, nil
+}
+
+// SignToken creates the access token of claims signed by secret. The token is the
+// base64url encoded JSON of the claims and its HMAC-SHA256, separated by a dot
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(secret, encoded)), nil
+}
+
+// VerifyToken returns the claims of token if it is signed by secret, grants the path of pathKey
+// and has not expired at now
+func VerifyToken(secret []byte, token, pathKey string, now time.Time) (*TokenClaims, error) {
+	dot := strings.IndexByte(token, '.')
+	if dot < 0 {
+		return nil, ErrInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, token[:dot])) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:dot])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !strings.EqualFold(claims.PathKey, pathKey) {
+		return nil, ErrInvalidToken
+	}
+	if now.Unix() >= claims.Expiry {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func tokenMAC(secret []byte, encodedPayload string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(encodedPayload))
+	return h.Sum(nil)
+}
+
+// TokenAuthorizer authorizes the connections presenting an access token signed by
+// Secret in the token query parameter, the client is restricted by the claims of the token
+type TokenAuthorizer struct {
+	Secret []byte
+}
+
+// Authorize verifies the token of a and sets its Grant
+func (ta *TokenAuthorizer) Authorize(a *Admission) error {
+	token := a.Query.Get("token")
+	if token == "" {
+		return ErrNotAuthorized
+	}
+	claims, err := VerifyToken(ta.Secret, token, a.PathKey, time.Now())
+	if err != nil {
+		return err
+	}
+	a.Grant, err = claims.grant()
+	return err
+}
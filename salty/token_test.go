@@ -0,0 +1,116 @@
+package salty
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	prot "github.com/OguzhanE/saltyrtc-server-go/salty/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1600000000, 0)
+	claims := TokenClaims{PathKey: testPathKey, Expiry: now.Add(time.Minute).Unix(), MaxResponders: 1, Role: "responder"}
+	token, err := SignToken(secret, claims)
+	require.Nil(t, err)
+
+	got, err := VerifyToken(secret, token, strings.ToUpper(testPathKey), now)
+	require.Nil(t, err)
+	require.Equal(t, claims, *got)
+
+	_, err = VerifyToken([]byte("other"), token, testPathKey, now)
+	require.Equal(t, ErrInvalidToken, err)
+	_, err = VerifyToken(secret, token, strings.Repeat("b2", 32), now)
+	require.Equal(t, ErrInvalidToken, err)
+	_, err = VerifyToken(secret, token, testPathKey, now.Add(time.Minute))
+	require.Equal(t, ErrTokenExpired, err)
+
+	dot := strings.IndexByte(token, '.')
+	tampered, err := SignToken(secret, TokenClaims{PathKey: testPathKey, Expiry: claims.Expiry})
+	require.Nil(t, err)
+	_, err = VerifyToken(secret, tampered[:strings.IndexByte(tampered, '.')]+token[dot:], testPathKey, now)
+	require.Equal(t, ErrInvalidToken, err)
+	for _, malformed := range []string{"", "nodot", token[:dot], token + "!"} {
+		_, err = VerifyToken(secret, malformed, testPathKey, now)
+		require.Equal(t, ErrInvalidToken, err, malformed)
+	}
+}
+
+func TestTokenAuthorizer(t *testing.T) {
+	ta := &TokenAuthorizer{Secret: []byte("secret")}
+	expiry := time.Now().Add(time.Minute).Unix()
+
+	a := &Admission{PathKey: testPathKey, Query: url.Values{}}
+	require.Equal(t, ErrNotAuthorized, ta.Authorize(a))
+
+	token, err := SignToken(ta.Secret, TokenClaims{PathKey: testPathKey, Expiry: expiry, MaxResponders: 2, Role: "initiator"})
+	require.Nil(t, err)
+	a.Query.Set("token", token)
+	require.Nil(t, ta.Authorize(a))
+	require.Equal(t, Grant{Role: RoleInitiator, MaxResponders: 2}, a.Grant)
+
+	token, err = SignToken(ta.Secret, TokenClaims{PathKey: testPathKey, Expiry: expiry, Role: "observer"})
+	require.Nil(t, err)
+	a.Query.Set("token", token)
+	require.Equal(t, ErrInvalidToken, ta.Authorize(a))
+}
+
+func TestClient_CheckRole(t *testing.T) {
+	c := &Client{}
+	require.Nil(t, c.checkRole(RoleInitiator))
+	require.Nil(t, c.checkRole(RoleResponder))
+
+	c.grant.Role = RoleResponder
+	require.Nil(t, c.checkRole(RoleResponder))
+	require.Equal(t, ErrRoleNotGranted, c.checkRole(RoleInitiator))
+	require.Equal(t, prot.CloseCodePolicyViolation, CloseCodeOf(ErrRoleNotGranted))
+}
+
+func TestServeHTTP_Token(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	s.Authorizer = &TokenAuthorizer{Secret: []byte("secret")}
+
+	resp, err := http.Get(hs.URL + "/salty/" + testPathKey)
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	token, err := SignToken([]byte("secret"), TokenClaims{PathKey: testPathKey, Expiry: time.Now().Add(time.Minute).Unix()})
+	require.Nil(t, err)
+	dialStream(t, "ws"+strings.TrimPrefix(hs.URL, "http")+"/salty/"+testPathKey+"?token="+token)
+}
+
+func TestClient_MaxRespondersOfPath(t *testing.T) {
+	s, hs := newStreamTestServer(t)
+	s.Authorizer = &TokenAuthorizer{Secret: []byte("secret")}
+	initiator := newTestPeer(t)
+	connect := func(p *testPeer, maxResponders int) {
+		claims := TokenClaims{PathKey: initiator.pathKey(), Expiry: time.Now().Add(time.Minute).Unix(), MaxResponders: maxResponders}
+		token, err := SignToken([]byte("secret"), claims)
+		require.Nil(t, err)
+		p.connect(hs.URL, initiator.pathKey()+"?token="+token)
+	}
+	connect(initiator, 0)
+	initiator.authInitiator(s, 0)
+
+	first := newTestPeer(t)
+	connect(first, 1)
+	first.authResponder(s, 0)
+
+	// the limit of the first responder applies to the path, not the larger one of the second
+	second := newTestPeer(t)
+	connect(second, 2)
+	second.sendToServer(struct {
+		Type prot.MessageType `codec:"type"`
+		Key  []byte           `codec:"key"`
+	}{prot.ClientHello, second.box.Pk[:]}, true)
+	second.sendToServer(second.clientAuth(s.permanentBoxes[0].Pk, 0), false)
+	requireClosedWith(t, second.rw, prot.CloseCodePathFullError)
+	path, ok := s.paths.Get(initiator.pathKey())
+	require.True(t, ok)
+	require.Equal(t, 1, path.numResponders())
+}